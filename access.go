@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strings"
+)
+
+// accessPurposes contains what a client can be allowed to do by the access rules:
+// "status" (see the server status), "wake" (start the server joining), "online" (connect while the server is online)
+var accessPurposes = []string{"status", "wake", "online"}
+
+// accessRule decides which source ips are allowed for a purpose.
+// an ip in DenyCIDRs or from a country in DenyCountries is denied. then, if AllowCIDRs or AllowCountries are
+// not empty, the ip must be in AllowCIDRs or from a country in AllowCountries
+type accessRule struct {
+	// networks (example: "192.168.1.0/24") or single ips
+	AllowCIDRs []string
+	DenyCIDRs  []string
+	// iso country codes (example: "IT"), need Access.GeoIPDatabase
+	AllowCountries []string
+	DenyCountries  []string
+}
+
+// accessRule returns the access rule of purpose
+func (c *configuration) accessRule(purpose string) accessRule {
+	switch purpose {
+	case "status":
+		return c.Access.Status
+	case "wake":
+		return c.Access.Wake
+	}
+	return c.Access.Online
+}
+
+// checkAccess returns true if clientAddress is allowed for purpose by the access rules
+func checkAccess(clientAddress, purpose string) bool {
+	rule := conf().accessRule(purpose)
+	ip := net.ParseIP(strings.Trim(clientAddress, "[]"))
+	if ip == nil {
+		return true
+	}
+
+	if matchCIDRs(rule.DenyCIDRs, ip) {
+		return false
+	}
+
+	var country string
+	if len(rule.AllowCountries) > 0 || len(rule.DenyCountries) > 0 {
+		var err error
+		country, err = lookupCountry(ip)
+		// a database that can't be loaded is logged once by loadGeoipDatabase
+		if err != nil && !errors.Is(err, errGeoipUnavailable) {
+			logMsh.Warn("checkAccess: country lookup failed", "client_ip", clientAddress, "error", err)
+		}
+	}
+	if country != "" && slices.ContainsFunc(rule.DenyCountries, func(c string) bool { return strings.EqualFold(c, country) }) {
+		return false
+	}
+
+	if len(rule.AllowCIDRs) == 0 && len(rule.AllowCountries) == 0 {
+		return true
+	}
+	if matchCIDRs(rule.AllowCIDRs, ip) {
+		return true
+	}
+	return country != "" && slices.ContainsFunc(rule.AllowCountries, func(c string) bool { return strings.EqualFold(c, country) })
+}
+
+// checkAnyAccess returns true if clientAddress is allowed for at least one purpose
+// (used to close the connections that can't do anything as soon as they are accepted)
+func checkAnyAccess(clientAddress string) bool {
+	for _, purpose := range accessPurposes {
+		if checkAccess(clientAddress, purpose) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCIDRs returns true if ip is in one of the networks (or is one of the ips) of list
+func matchCIDRs(list []string, ip net.IP) bool {
+	for _, item := range list {
+		if _, network, err := net.ParseCIDR(item); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if single := net.ParseIP(item); single != nil && single.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
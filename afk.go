@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// afkPolicies contains what msh can do with the afk players: "none" (they are only shown in status),
+// "warn" (they are warned in game), "stop" (they are warned and, when all the players online are afk,
+// the server is considered empty and stopped)
+var afkPolicies = []string{"none", "warn", "stop"}
+
+// sessions whose player was already warned of being afk (session id -> true)
+var afkWarned = map[int64]bool{}
+var afkMutex = &sync.Mutex{}
+
+// afkPlayerNames returns the names of the players online that are afk
+func afkPlayerNames() []string {
+	names := []string{}
+	for _, s := range listSessions() {
+		if s.AFK {
+			names = append(names, s.PlayerName)
+		}
+	}
+	return names
+}
+
+// allPlayersAFK returns true if there are players online and all of them are afk
+func allPlayersAFK() bool {
+	joined := 0
+	for _, s := range listSessions() {
+		if s.Kind != "join" {
+			continue
+		}
+		if !s.AFK {
+			return false
+		}
+		joined++
+	}
+	return joined > 0
+}
+
+// tellPlayer sends message to playerName only, with Countdown.Command ("say": tell, "tellraw": colored message).
+// the name comes from the login packet of the client: names that a minecraft client can't use
+// (spaces, selectors like @a, control characters) never reach the server console
+func tellPlayer(playerName, message string) {
+	if !validPlayerName.MatchString(playerName) {
+		logMsh.Warn("tellPlayer: invalid player name, message not sent", "player", fmt.Sprintf("%q", playerName))
+		return
+	}
+	command := "tell " + playerName + " " + message
+	if conf().Countdown.Command == "tellraw" {
+		text, _ := json.Marshal(map[string]string{"text": message, "color": "gray"})
+		command = "tellraw " + playerName + " " + string(text)
+	}
+	if _, err := sendServerCommand(command); err != nil {
+		logMsh.Warn("tellPlayer: error while sending the message", "player", playerName, "error", err)
+	}
+}
+
+// watchAfk applies the afk policy every 30 seconds
+func watchAfk() {
+	for range time.Tick(30 * time.Second) {
+		applyAfk()
+	}
+}
+
+// applyAfk warns the players that became afk and, with the "stop" policy, stops the server when all the
+// players online are afk (with the stop countdown, cancelled if a player is active again)
+func applyAfk() {
+	c := conf()
+	if c.Afk.Minutes <= 0 || c.Afk.Policy == "none" {
+		return
+	}
+
+	openSessions := listSessions()
+
+	afkMutex.Lock()
+	var toWarn []string
+	open := map[int64]bool{}
+	for _, s := range openSessions {
+		open[s.ID] = true
+		switch {
+		case s.AFK && !afkWarned[s.ID]:
+			afkWarned[s.ID] = true
+			toWarn = append(toWarn, s.PlayerName)
+			logMsh.Info("player is afk", "player", s.PlayerName, "client_ip", s.ClientAddress, "last_active", s.LastActive.Format(time.TimeOnly))
+		case !s.AFK && afkWarned[s.ID]:
+			delete(afkWarned, s.ID)
+			logMsh.Info("player is not afk anymore", "player", s.PlayerName, "client_ip", s.ClientAddress)
+		}
+	}
+	// forget the sessions that are closed
+	for id := range afkWarned {
+		if !open[id] {
+			delete(afkWarned, id)
+		}
+	}
+	afkMutex.Unlock()
+
+	for _, playerName := range toWarn {
+		tellPlayer(playerName, strings.ReplaceAll(c.Messages.AfkWarning, "{player}", playerName))
+	}
+
+	// the afk players don't keep the server running (as long as msh is allowed to stop it)
+	if c.Afk.Policy != "stop" || serverStatus != "online" || countdownRunning.Load() || !allPlayersAFK() {
+		return
+	}
+	if isMaintenance() || scheduleMode(time.Now()) == "always_on" || isPrewarmHolding() {
+		return
+	}
+	logMsh.Info("all the players online are afk, stopping the minecraft server", "players", afkPlayerNames())
+	go stopWithCountdown(time.Now().Add(countdownDuration()), "afk", false, func() bool { return allPlayersAFK() && !isMaintenance() })
+}
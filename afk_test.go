@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTellPlayerRejectsInvalidNames(t *testing.T) {
+	commandsFile := filepath.Join(t.TempDir(), "commands")
+	useTestConfiguration(t, func(c *configuration) {
+		c.Basic.SendCommandToServer = `printf '%s\n' "$MSH_COMMAND" >> ` + commandsFile
+	})
+	previousStatus := serverStatus
+	serverStatus = "online"
+	defer func() { serverStatus = previousStatus }()
+
+	for _, playerName := range []string{"Steve", "@a", "x kill @e", "a\nstop", "", "Averyveryverylongname"} {
+		tellPlayer(playerName, "you are afk")
+	}
+
+	data, err := os.ReadFile(commandsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commands := strings.TrimSpace(string(data)); commands != "tell Steve you are afk" {
+		t.Errorf("commands sent:\n%s\nwant only: tell Steve you are afk", commands)
+	}
+}
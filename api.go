@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statusInfo is the answer to GET /status
+type statusInfo struct {
+	State          string        `json:"state"`
+	Players        int           `json:"players"`
+	PlayerNames    []string      `json:"playerNames"`
+	ETA            int           `json:"eta"`       // seconds left until the server is online (only when starting)
+	Uptime         int           `json:"uptime"`    // seconds since the server was started (0 if offline)
+	MshUptime      int           `json:"mshUptime"` // seconds since msh was started
+	Version        string        `json:"version"`
+	ServerVersion  string        `json:"serverVersion"`
+	ServerProtocol string        `json:"serverProtocol"`
+	Maintenance    bool          `json:"maintenance"`
+	Schedule       string        `json:"schedule"`              // mode of the current schedule window
+	NextOpening    string        `json:"nextOpening,omitempty"` // when the schedule opens again (only when closed)
+	Budget         []budgetUsage `json:"budget,omitempty"`      // runtime budgets (updated every 30 seconds)
+	QuorumVotes    int           `json:"quorumVotes,omitempty"` // players waiting for the wake quorum
+	AfkPlayers     []string      `json:"afkPlayers,omitempty"`  // players online that are afk
+}
+
+// startAPI starts the http control api if Api.Enabled is true
+func startAPI() {
+	if !conf().Api.Enabled {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", requireRole("read", apiStatus))
+	mux.HandleFunc("GET /sessions", requireRole("read", apiSessions))
+	mux.HandleFunc("GET /metrics", requireRole("read", apiMetrics))
+	mux.HandleFunc("POST /start", requireRole("operator", apiStart))
+	mux.HandleFunc("POST /stop", requireRole("operator", apiStop))
+	mux.HandleFunc("POST /maintenance", requireRole("operator", apiMaintenance))
+	mux.HandleFunc("GET /config", requireRole("operator", apiConfig))
+	mux.HandleFunc("POST /config/reload", requireRole("operator", apiConfigReload))
+	mux.HandleFunc("GET /history/{query}", requireRole("read", apiHistory))
+	mux.HandleFunc("GET /report", requireRole("read", apiReport))
+	mux.HandleFunc("GET /quotas", requireRole("read", apiQuotas))
+	mux.HandleFunc("DELETE /quotas/{player}", requireRole("operator", apiResetQuota))
+	mux.HandleFunc("GET /prewarm", requireRole("read", apiPrewarm))
+	mux.HandleFunc("GET /log/levels", requireRole("read", apiLogLevels))
+	mux.HandleFunc("POST /log/levels", requireRole("operator", apiSetLogLevel))
+	mux.HandleFunc("POST /notifications/test", requireRole("operator", apiNotificationsTest))
+	addDashboardRoutes(mux)
+
+	address := conf().Api.Host + ":" + conf().Api.Port
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		var err error
+		if conf().Api.TLSCert != "" {
+			logMsh.Info("control api listening", "url", "https://"+address)
+			err = server.ListenAndServeTLS(conf().Api.TLSCert, conf().Api.TLSKey)
+		} else {
+			logMsh.Info("control api listening", "url", "http://"+address)
+			err = server.ListenAndServe()
+		}
+		logMsh.Error("startAPI: control api stopped", "error", err)
+	}()
+}
+
+// getStatusInfo collects the current state of msh and of the minecraft server
+func getStatusInfo() statusInfo {
+	status := statusInfo{
+		State:          serverStatus,
+		PlayerNames:    onlinePlayerNames(),
+		MshUptime:      int(time.Since(mshStartTime).Seconds()),
+		Version:        info[2],
+		ServerVersion:  serverVersion,
+		ServerProtocol: serverProtocol,
+		Maintenance:    isMaintenance(),
+		Schedule:       scheduleMode(time.Now()),
+		Budget:         getBudgetUsage(),
+	}
+	if afk := afkPlayerNames(); len(afk) > 0 {
+		status.AfkPlayers = afk
+	}
+	if isQuorumEnabled() {
+		status.QuorumVotes = pendingQuorumVotes()
+	}
+	if status.Schedule == "closed" {
+		if next := nextScheduleOpening(time.Now()); !next.IsZero() {
+			status.NextOpening = next.Format(time.RFC3339)
+		}
+	}
+	status.Players = len(status.PlayerNames)
+	if status.State == "starting" {
+		status.ETA = timeLeftUntilUp
+	}
+	if startTime := serverStartTime; !startTime.IsZero() {
+		status.Uptime = int(time.Since(startTime).Seconds())
+	}
+	return status
+}
+
+// apiStatus answers with the current state of the server
+func apiStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, getStatusInfo())
+}
+
+// apiStart starts the minecraft server if it is offline
+func apiStart(w http.ResponseWriter, r *http.Request) {
+	err := startMinecraftServer(event{ClientAddress: r.RemoteAddr, Reason: "control api"})
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	logMsh.Info("minecraft server start requested from control api", "client_ip", r.RemoteAddr)
+	writeJSON(w, http.StatusAccepted, getStatusInfo())
+}
+
+// apiStop stops the minecraft server. with force=true it is stopped even if there are players online
+func apiStop(w http.ResponseWriter, r *http.Request) {
+	var force bool
+	if forceString := r.URL.Query().Get("force"); forceString != "" {
+		var err error
+		force, err = strconv.ParseBool(forceString)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "force must be true or false")
+			return
+		}
+	}
+
+	err := requestStopMinecraftServer(force, "control api")
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	logMsh.Info("minecraft server stop requested from control api", "client_ip", r.RemoteAddr, "force", force)
+	writeJSON(w, http.StatusAccepted, getStatusInfo())
+}
+
+// apiMaintenance turns the maintenance mode on or off (?enabled=true or false)
+func apiMaintenance(w http.ResponseWriter, r *http.Request) {
+	enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "enabled must be true or false")
+		return
+	}
+	if err := setMaintenance(enabled, "control api"); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, getStatusInfo())
+}
+
+// apiSessions answers with the list of the open sessions
+func apiSessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, listSessions())
+}
+
+// apiConfig answers with the current configuration (without the secrets)
+func apiConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, conf().redacted())
+}
+
+// apiConfigReload reloads the config file
+func apiConfigReload(w http.ResponseWriter, r *http.Request) {
+	err := reloadConfiguration()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, conf().redacted())
+}
+
+// apiHistory answers with the history query playtime, hours or wakes (?days=N: only the last N days)
+func apiHistory(w http.ResponseWriter, r *http.Request) {
+	var days int
+	if daysString := r.URL.Query().Get("days"); daysString != "" {
+		var err error
+		days, err = strconv.Atoi(daysString)
+		if err != nil || days < 0 {
+			writeError(w, http.StatusBadRequest, "days must be a positive number")
+			return
+		}
+	}
+
+	query := r.PathValue("query")
+	if !slices.Contains(historyQueries, query) {
+		writeError(w, http.StatusNotFound, "unknown history query (valid: "+strings.Join(historyQueries, ", ")+")")
+		return
+	}
+	result, err := queryHistory(query, days)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// apiReport answers with the uptime and energy report (?period=day, week or month, default day)
+func apiReport(w http.ResponseWriter, r *http.Request) {
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "day"
+	}
+	report, err := buildEnergyReport(period)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// apiQuotas answers with the wakes of each player
+func apiQuotas(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, listWakeQuotas())
+}
+
+// apiResetQuota resets the wake quota of a player ("*": of all the players)
+func apiResetQuota(w http.ResponseWriter, r *http.Request) {
+	if err := resetWakeQuota(r.PathValue("player")); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	logMsh.Info("wake quota reset from control api", "player", r.PathValue("player"))
+	writeJSON(w, http.StatusOK, listWakeQuotas())
+}
+
+// apiPrewarm answers with the learned join probabilities, the prewarm budget and decisions
+func apiPrewarm(w http.ResponseWriter, r *http.Request) {
+	info, err := getPrewarmInfo()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// apiLogLevels answers with the log level of each subsystem
+func apiLogLevels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, getLogLevels())
+}
+
+// apiSetLogLevel changes the log level of a subsystem (?subsystem=proxy&level=debug)
+func apiSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	err := setLogLevel(r.URL.Query().Get("subsystem"), r.URL.Query().Get("level"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, getLogLevels())
+}
+
+// apiNotificationsTest sends a test notification to the sink ?sink=name (all sinks if not specified)
+func apiNotificationsTest(w http.ResponseWriter, r *http.Request) {
+	results, err := sendTestNotification(r.URL.Query().Get("sink"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// writeJSON writes value as json with the specified status code
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		logMsh.Debug("writeJSON: error while encoding answer", "error", err)
+	}
+}
+
+// writeError writes an error message as json with the specified status code
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, map[string]string{"error": message})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// roles of the control api tokens. an operator can do everything a reader can do
+var apiRoleLevel = map[string]int{
+	"read":     1,
+	"operator": 2,
+}
+
+// auditEntry is a line of the audit log
+type auditEntry struct {
+	Time          time.Time `json:"time"`
+	Token         string    `json:"token"`
+	Role          string    `json:"role"`
+	RemoteAddress string    `json:"remoteAddress"`
+	Method        string    `json:"method"`
+	Path          string    `json:"path"`
+	StatusCode    int       `json:"statusCode"`
+}
+
+var auditMutex = &sync.Mutex{}
+
+// statusRecorder is used to know the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Flush is needed by handlers that stream their answer
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// authenticate returns the token that matches the request bearer token (nil if none matches).
+// GET requests can also pass the token as "token" query parameter (used by the dashboard event stream)
+func authenticate(r *http.Request) *apiToken {
+	bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found && r.Method == http.MethodGet {
+		bearer = r.URL.Query().Get("token")
+	}
+	if bearer == "" {
+		return nil
+	}
+
+	hash := sha256.Sum256([]byte(bearer))
+	hashHex := []byte(hex.EncodeToString(hash[:]))
+
+	tokens := conf().Api.Tokens
+	for i := range tokens {
+		expected := []byte(strings.ToLower(strings.TrimPrefix(tokens[i].Hash, "sha256:")))
+		if subtle.ConstantTimeCompare(hashHex, expected) == 1 {
+			return &tokens[i]
+		}
+	}
+	return nil
+}
+
+// requireRole wraps handler so that it is executed only for requests with a token that has at least the specified role.
+// every request is recorded in the audit log
+func requireRole(role string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		token := authenticate(r)
+
+		// the token must not end up in the audit log
+		query := r.URL.Query()
+		query.Del("token")
+		path := r.URL.Path
+		if len(query) > 0 {
+			path += "?" + query.Encode()
+		}
+
+		entry := auditEntry{
+			Time:          time.Now(),
+			RemoteAddress: r.RemoteAddr,
+			Method:        r.Method,
+			Path:          path,
+		}
+		if token != nil {
+			entry.Token = token.Name
+			entry.Role = token.Role
+		}
+
+		if token == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(recorder, http.StatusUnauthorized, "missing or invalid token")
+		} else if apiRoleLevel[token.Role] < apiRoleLevel[role] {
+			writeError(recorder, http.StatusForbidden, "role "+token.Role+" is not allowed to do this")
+		} else {
+			handler(recorder, r)
+		}
+
+		entry.StatusCode = recorder.statusCode
+		writeAuditEntry(entry)
+	}
+}
+
+// writeAuditEntry appends entry to the audit log file
+func writeAuditEntry(entry auditEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	file, err := os.OpenFile(conf().Api.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logMsh.Error("writeAuditEntry: error while opening audit log", "error", err)
+		return
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		logMsh.Error("writeAuditEntry: error while writing audit log", "error", err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// budgetPeriods contains the periods of the runtime budgets
+var budgetPeriods = []string{"day", "week", "month"}
+
+// budgetUsage is the running time used in the current period of a runtime budget
+type budgetUsage struct {
+	Period     string    `json:"period"`
+	LimitHours float64   `json:"limitHours"`
+	UsedHours  float64   `json:"usedHours"`
+	Reset      time.Time `json:"reset"` // when the next period starts
+}
+
+var errBudgetExhausted = errors.New("runtime budget exhausted")
+
+// runtime budgets computed by the last check
+var currentBudgetUsage []budgetUsage
+var budgetMutex = &sync.Mutex{}
+
+// budgetLimit returns the hours the server can run in each period ("day", "week" or "month", 0: no limit)
+func (c *configuration) budgetLimit(period string) float64 {
+	switch period {
+	case "day":
+		return c.Budget.DailyHours
+	case "week":
+		return c.Budget.WeeklyHours
+	}
+	return c.Budget.MonthlyHours
+}
+
+// budgetPeriod returns the start of the current period and the start of the next one
+// (midnight, monday or the first day of the month in Schedule.Timezone)
+func budgetPeriod(period string, now time.Time) (time.Time, time.Time) {
+	now = now.In(conf().scheduleLocation())
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case "day":
+		return day, day.AddDate(0, 0, 1)
+	case "week":
+		monday := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		return monday, monday.AddDate(0, 0, 7)
+	}
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return month, month.AddDate(0, 1, 0)
+}
+
+// computeBudgetUsage returns the running time used in the current period of each configured budget.
+// the running time is the time between the start and the stop of each wake recorded in the history
+func computeBudgetUsage(now time.Time) ([]budgetUsage, error) {
+	c := conf()
+
+	usages := []budgetUsage{}
+	earliest := now
+	for _, period := range budgetPeriods {
+		if limit := c.budgetLimit(period); limit > 0 {
+			start, reset := budgetPeriod(period, now)
+			usages = append(usages, budgetUsage{Period: period, LimitHours: limit, Reset: reset})
+			earliest = minTime(earliest, start)
+		}
+	}
+	if len(usages) == 0 {
+		return usages, nil
+	}
+
+	records, err := readHistory(earliest)
+	if err != nil {
+		return nil, err
+	}
+	var wakes []historyRecord
+	for _, record := range records {
+		if record.Type == "wake" {
+			wakes = append(wakes, record)
+		}
+	}
+	// the current wake is written in the history only when the server stops
+	historyMutex.Lock()
+	if currentWake != nil {
+		wakes = append(wakes, historyRecord{Type: "wake", Since: currentWake.Since, Until: now})
+	}
+	historyMutex.Unlock()
+
+	for i := range usages {
+		start, _ := budgetPeriod(usages[i].Period, now)
+		for _, wake := range wakes {
+			usages[i].UsedHours += max(minTime(wake.Until, now).Sub(maxTime(wake.Since, start)).Hours(), 0)
+		}
+	}
+	return usages, nil
+}
+
+// getBudgetUsage returns the runtime budgets computed by the last check
+func getBudgetUsage() []budgetUsage {
+	budgetMutex.Lock()
+	defer budgetMutex.Unlock()
+	return append([]budgetUsage{}, currentBudgetUsage...)
+}
+
+// updateBudgetUsage computes the runtime budgets again
+func updateBudgetUsage() []budgetUsage {
+	usages, err := computeBudgetUsage(time.Now())
+	if err != nil {
+		logMsh.Warn("updateBudgetUsage: error while reading the history", "error", err)
+		return getBudgetUsage()
+	}
+	budgetMutex.Lock()
+	currentBudgetUsage = usages
+	budgetMutex.Unlock()
+	return usages
+}
+
+// budgetExhaustedUntil returns when the exhausted runtime budgets reset (zero if no budget is exhausted)
+func budgetExhaustedUntil(now time.Time) time.Time {
+	var until time.Time
+	for _, usage := range getBudgetUsage() {
+		if usage.UsedHours >= usage.LimitHours && usage.Reset.After(now) && usage.Reset.After(until) {
+			until = usage.Reset
+		}
+	}
+	return until
+}
+
+// budgetExhaustedMessage returns the text shown to the players that try to join while a budget is exhausted
+func budgetExhaustedMessage() string {
+	reset := budgetExhaustedUntil(time.Now()).In(conf().scheduleLocation()).Format("Mon 2 Jan 15:04")
+	return strings.ReplaceAll(conf().Messages.BudgetExhausted, "{reset}", reset)
+}
+
+// watchBudget checks the runtime budgets every 30 seconds
+func watchBudget() {
+	updateBudgetUsage()
+	for range time.Tick(30 * time.Second) {
+		applyBudget(updateBudgetUsage())
+	}
+}
+
+// applyBudget starts the stop countdown so that the server stops when a budget is exhausted
+// (nothing is done during maintenance)
+func applyBudget(usages []budgetUsage) {
+	if len(usages) == 0 || serverStatus == "offline" || isMaintenance() || countdownRunning.Load() {
+		return
+	}
+
+	// the budget that will be exhausted first
+	limiting := usages[0]
+	for _, usage := range usages {
+		if usage.LimitHours-usage.UsedHours < limiting.LimitHours-limiting.UsedHours {
+			limiting = usage
+		}
+	}
+	remaining := time.Duration((limiting.LimitHours - limiting.UsedHours) * float64(time.Hour))
+
+	// the budget is checked every 30 seconds: the countdown starts early enough to send all the warnings
+	// (an empty server is stopped when the budget is exhausted)
+	if remaining > countdownDuration()+30*time.Second || (players == 0 && remaining > 0) {
+		return
+	}
+	logMsh.Warn("runtime budget almost exhausted, stopping the minecraft server", "period", limiting.Period, "used_hours", limiting.UsedHours, "reset", limiting.Reset)
+	go stopWithCountdown(time.Now().Add(max(remaining, 0)), "budget", false, func() bool { return !isMaintenance() })
+}
@@ -0,0 +1,1096 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
+)
+
+// configuration contains all the msh settings.
+// it is loaded from the config file (json) and then overridden by environment variables and command line flags.
+type configuration struct {
+	Basic struct {
+		MinRAM string
+		MaxRAM string
+		McPath string
+		McFile string
+		// command used to start the minecraft server (if empty it is built from MinRAM, MaxRAM, McPath and McFile)
+		StartMinecraftServer string
+		StopMinecraftServer  string
+		// command used to send a console command to the minecraft server (the console command is in $MSH_COMMAND)
+		SendCommandToServer           string
+		MinecraftServerStartupTime    int
+		TimeBeforeStoppingEmptyServer int
+	}
+	Messages struct {
+		HibernationInfo string
+		StartingInfo    string
+		// {timeLeft} is replaced with the seconds left until the server is up
+		StartCommandIssued string
+		ServerIsStarting   string
+		// shown to a player whose join didn't start the server because a "starting" hook blocked it
+		StartBlocked string
+		// shown to a player that is not allowed to wake the server (see Wake)
+		WakeDenied string
+		// shown to a player that tried to join too many times (see Limits.WakesPerMinute)
+		TooManyAttempts string
+		// shown to a player whose ip is not allowed to join while the server is online (see Access.Online)
+		JoinDenied string
+		// server info and version text shown during maintenance, text shown to the players that try to join
+		MaintenanceInfo    string
+		MaintenanceVersion string
+		MaintenanceJoin    string
+		// shown to a player that tries to join while the schedule is closed ({opening} is replaced with the next opening time)
+		ScheduleClosed string
+		// sent in game before the server is stopped ({time} is replaced with the time left, {reason} with why it's stopped)
+		StopCountdown string
+		StopNow       string
+		// sent to a player that became afk ({player} is replaced with the player name)
+		AfkWarning string
+		// shown to a player that tries to join while a runtime budget is exhausted ({reset} is replaced with the reset time)
+		BudgetExhausted string
+		// shown to a player waiting for the wake quorum and in the server list while players are waiting
+		// ({votes} is replaced with the players waiting, {quorum} with Quorum.Players)
+		QuorumWaiting string
+		QuorumInfo    string
+		// shown to a player that reached WakeQuota.MaxPerDay or is waiting for WakeQuota.Cooldown
+		// ({limit} is replaced with the limit, {reset} with when the player can wake the server again)
+		WakeQuotaExceeded string
+		WakeCooldown      string
+	}
+	Advanced struct {
+		ListenHost     string
+		ListenPort     string
+		TargetHost     string
+		TargetPort     string
+		Debug          bool
+		ServerVersion  string
+		ServerProtocol string
+	}
+	Log struct {
+		// "text" or "json"
+		Format string
+		// minimum level of the logged messages: debug, info, warn or error (Advanced.Debug true is the same as debug)
+		Level string
+		// levels of the subsystems (if empty: Level). proxy: client connections, process: minecraft server process,
+		// protocol: minecraft protocol
+		ProxyLevel    string
+		ProcessLevel  string
+		ProtocolLevel string
+	}
+	Wake struct {
+		// players that can wake the server (names or uuids). if Allowlist is empty and UseWhitelist and UseOps
+		// are false anyone can wake it
+		Allowlist []string
+		// players that can never wake the server (names or uuids)
+		Denylist []string
+		// if true the players in {McPath}whitelist.json and {McPath}ops.json can wake the server
+		UseWhitelist bool
+		UseOps       bool
+		// if true the players in {McPath}banned-players.json can't wake the server
+		UseBannedPlayers bool
+	}
+	WakeQuota struct {
+		// wakes each player can do per day (in Schedule.Timezone, 0: no limit)
+		MaxPerDay int
+		// minimum seconds between two wakes of the same player (0: no cooldown)
+		Cooldown int
+		// file where the wakes of the players are stored (default: {McPath}msh-wake-quotas.json)
+		File string
+	}
+	Quorum struct {
+		// players that must try to join within {Window} seconds to wake the server (0 or 1: any player wakes it)
+		Players int
+		Window  int
+		// how the players are told apart: "name" or "ip"
+		DistinctBy string
+		// players that wake the server without waiting for the quorum (names or uuids)
+		Admins []string
+	}
+	Schedule struct {
+		// timezone of the windows (example: "Europe/Rome", default: local time)
+		Timezone string
+		// the first window that contains the current time sets the mode, outside the windows the mode is "wakeable"
+		Windows []scheduleWindow
+	}
+	Prewarm struct {
+		// if true the server is started before the hours in which players are likely to join
+		Enabled bool
+		// days of history used to learn the join probability of each hour of the week
+		Days int
+		// join probability (0-1) over which the server is started
+		Threshold float64
+		// minutes before the hour in which the server is started
+		Lead int
+		// maximum hours in the last 7 days the server can run after a prewarm start before a player joins
+		BudgetHours float64
+	}
+	Budget struct {
+		// hours the minecraft server can run each day, week (from monday) and month in Schedule.Timezone (0: no limit)
+		DailyHours   float64
+		WeeklyHours  float64
+		MonthlyHours float64
+	}
+	Afk struct {
+		// minutes in which a player must be active to not be afk (0: afk detection disabled)
+		Minutes int
+		// client to server bytes per minute over which a player is active (an afk client only sends keep-alive and small movement packets)
+		BytesPerMinute int
+		// what to do with the afk players: "none", "warn" (they are warned in game) or "stop" (they are warned and
+		// the server is stopped when all the players online are afk)
+		Policy string
+	}
+	Countdown struct {
+		// seconds before a stop in which the players online are warned (schedule, budget, forced stops)
+		Warnings []int
+		// seconds the players are warned before an empty server is stopped, in case the player count is wrong (0: no warning)
+		IdleWarning int
+		// command used for the warnings: "say" or "tellraw"
+		Command string
+		// if true "save-all" is sent to the server before it is stopped
+		SaveBeforeStop bool
+	}
+	Maintenance struct {
+		// the maintenance mode is on while this file exists (default: {McPath}msh-maintenance)
+		FlagFile string
+		// players that can wake and join the server during maintenance (names or uuids)
+		Admins []string
+		// if true also the players in {McPath}ops.json are admins
+		UseOps bool
+	}
+	Access struct {
+		// MaxMind format database (example: GeoLite2-Country.mmdb) used by the country rules
+		GeoIPDatabase string
+		// who can see the server status, start the server joining and connect while the server is online
+		Status accessRule
+		Wake   accessRule
+		Online accessRule
+	}
+	Limits struct {
+		// requests allowed from each source ip per minute and burst size (token bucket, 0 per minute: no limit)
+		// for all the connections, for the status requests and for the join attempts that would wake the server
+		ConnectionsPerMinute int
+		ConnectionsBurst     int
+		StatusPerMinute      int
+		StatusBurst          int
+		WakesPerMinute       int
+		WakesBurst           int
+		// maximum number of client connections open at the same time (0: no limit)
+		MaxConnections int
+		// a source ip that sends {MaxViolations} invalid packets in {ViolationWindow} seconds is banned for
+		// {BanDuration} seconds (MaxViolations 0: no bans)
+		MaxViolations   int
+		ViolationWindow int
+		BanDuration     int
+	}
+	History struct {
+		// if true the player sessions and the server wakes are recorded in {File} (json lines)
+		Enabled bool
+		// default: {McPath}msh-history.jsonl
+		File string
+		// the records that ended more than {RetentionDays} days ago are removed from {File} once a day (0: keep all)
+		RetentionDays int
+	}
+	Energy struct {
+		// estimated power used by the host (watts) while the minecraft server is running and while it is hibernating
+		RunningWatts float64
+		IdleWatts    float64
+	}
+	Console struct {
+		// if true the lines typed on the msh stdin are sent to the minecraft server console
+		Enabled bool
+		// if true the minecraft server log (logs/latest.log) is printed on the msh stdout with the prefix "[server] "
+		EchoServerOutput bool
+		// if RconAddress is set the console commands are sent using rcon instead of Basic.SendCommandToServer
+		RconAddress  string
+		RconPassword string
+	}
+	Control struct {
+		// unix socket used by the msh subcommands (status, start, stop, ...) to control the running msh
+		Socket string
+	}
+	Api struct {
+		// if true msh serves the http control api on {Host}:{Port}
+		Enabled bool
+		Host    string
+		Port    string
+		// if both are set the api is served over https
+		TLSCert string
+		TLSKey  string
+		// tokens allowed to use the api
+		Tokens []apiToken
+		// file where all the api requests are recorded (default: {McPath}msh-audit.log)
+		AuditLog string
+	}
+	Hooks struct {
+		// seconds after which a hook is killed (if the hook doesn't specify its own Timeout)
+		DefaultTimeout int
+		// commands executed when an event happens
+		Commands []hookCommand
+	}
+	Notifications struct {
+		// events notified by the sinks that don't list their own (same names as the hook events)
+		Events []string
+		// text of the notification for each event (go template, example: "{{.Player}} woke up the server")
+		Templates map[string]string
+		// attempts made after a failed delivery (waiting 5s, 10s, 20s, ...)
+		Retries int
+		// minimum seconds between two notifications of the same event on the same sink (crash loops don't spam)
+		MinInterval int
+		Sinks       []notificationSink
+	}
+	Mqtt struct {
+		// if true msh publishes the server state to the mqtt broker {Broker} (host:port)
+		Enabled  bool
+		Broker   string
+		ClientID string
+		Username string
+		Password string
+		// topics: {TopicPrefix}/availability, /state, /players, /player_list and /command (accepts "start" and "stop")
+		TopicPrefix string
+		// prefix of the home assistant discovery topics (if empty discovery is disabled)
+		DiscoveryPrefix string
+	}
+}
+
+// apiToken is a token allowed to use the control api
+type apiToken struct {
+	Name string
+	// sha256 of the token as hex string (example: printf '%s' "mytoken" | sha256sum)
+	Hash string
+	// "read" (status only) or "operator" (status, start, stop, config)
+	Role string
+}
+
+// notificationSink is a destination of the notifications
+type notificationSink struct {
+	Name string
+	// "webhook" (json POST), "discord" (discord webhook) or "smtp" (email)
+	Type string
+	// webhook url or, for smtp, the mail server host:port
+	URL string
+	// smtp only (Username and Password are optional)
+	Username string
+	Password string
+	From     string
+	To       []string
+	// events sent to this sink (if empty: Notifications.Events)
+	Events []string
+}
+
+// hookCommand is a command executed (with bash) when an event happens
+type hookCommand struct {
+	// one of hookEvents
+	Event   string
+	Command string
+	// seconds after which the command is killed (0: Hooks.DefaultTimeout)
+	Timeout int
+	// if true msh waits for the command and doesn't start/stop the server if it fails
+	// (only for "starting" and "stopping" events)
+	Blocking bool
+}
+
+// configPath is the path of the loaded config file
+var configPath string
+
+// configFlags contains the command line flags that override the config file ("Section.Field" -> value)
+var configFlags = map[string]string{}
+
+// configPointer holds the current configuration. use conf() to read it
+var configPointer atomic.Pointer[configuration]
+
+// configRestartFields contains the settings that are only applied when msh is restarted
+var configRestartFields = []string{"Basic.McPath", "Advanced.ListenHost", "Advanced.ListenPort", "Log.Format", "Console.Enabled", "Control.Socket", "Api.Enabled", "Api.Host", "Api.Port", "Api.TLSCert", "Api.TLSKey", "Mqtt.Enabled"}
+
+// conf returns the current configuration. the returned struct must not be modified
+func conf() *configuration {
+	return configPointer.Load()
+}
+
+// defaultConfiguration returns the configuration used when a setting is not specified
+func defaultConfiguration() *configuration {
+	c := &configuration{}
+
+	c.Basic.MinRAM = "512M"
+	c.Basic.MaxRAM = "2G"
+	c.Basic.McPath = "/minecraftserver/"
+	c.Basic.McFile = "minecraft_server.jar"
+	c.Basic.StopMinecraftServer = "screen -S minecraftSERVER -X stuff 'stop\\n'"
+	c.Basic.SendCommandToServer = "screen -S minecraftSERVER -X stuff \"$MSH_COMMAND\"$'\\n'"
+	c.Basic.MinecraftServerStartupTime = 20
+	c.Basic.TimeBeforeStoppingEmptyServer = 60
+
+	c.Messages.HibernationInfo = "                   &fserver status:\n                   &b&lHIBERNATING"
+	c.Messages.StartingInfo = "                   &fserver status:\n                    &6&lWARMING UP"
+	c.Messages.StartCommandIssued = "Server start command issued. Please wait... Time left: {timeLeft} seconds"
+	c.Messages.ServerIsStarting = "Server is starting. Please wait... Time left: {timeLeft} seconds"
+	c.Messages.StartBlocked = "The server can't be started right now. Please try again later."
+	c.Messages.WakeDenied = "You are not allowed to start this server."
+	c.Messages.TooManyAttempts = "Too many attempts. Please wait a minute and try again."
+	c.Messages.JoinDenied = "You are not allowed to join this server."
+	c.Messages.MaintenanceInfo = "                   &fserver status:\n                   &c&lMAINTENANCE"
+	c.Messages.MaintenanceVersion = "Maintenance"
+	c.Messages.MaintenanceJoin = "The server is under maintenance. Please try again later."
+	c.Messages.ScheduleClosed = "The server is closed. It opens again {opening}."
+	c.Messages.StopCountdown = "The server stops in {time}!"
+	c.Messages.StopNow = "The server is stopping now!"
+	c.Messages.AfkWarning = "{player}, you seem to be AFK: the server hibernates when nobody is playing."
+	c.Messages.BudgetExhausted = "The runtime budget is used up. It resets {reset}."
+	c.Messages.WakeQuotaExceeded = "You can wake the server {limit} times a day. Try again {reset}."
+	c.Messages.WakeCooldown = "You can wake the server once every {limit}. Try again {reset}."
+	c.Messages.QuorumWaiting = "{votes}/{quorum} players waiting, ask a friend"
+	c.Messages.QuorumInfo = "                   &fserver status:\n          &b&lHIBERNATING &r&7({votes}/{quorum} players waiting)"
+
+	c.Advanced.ListenHost = "0.0.0.0"
+	c.Advanced.ListenPort = "25555"
+	c.Advanced.TargetHost = "127.0.0.1"
+	c.Advanced.TargetPort = "25565"
+	c.Advanced.Debug = false
+	c.Advanced.ServerVersion = "WIP"
+	c.Advanced.ServerProtocol = "751"
+
+	c.Log.Format = "text"
+	c.Log.Level = "info"
+
+	c.WakeQuota.MaxPerDay = 0
+	c.WakeQuota.Cooldown = 0
+
+	c.Quorum.Window = 300
+	c.Quorum.DistinctBy = "name"
+
+	c.Prewarm.Enabled = false
+	c.Prewarm.Days = 28
+	c.Prewarm.Threshold = 0.6
+	c.Prewarm.Lead = 10
+	c.Prewarm.BudgetHours = 5
+
+	c.Afk.Minutes = 0
+	c.Afk.BytesPerMinute = 2048
+	c.Afk.Policy = "stop"
+
+	c.Countdown.Warnings = []int{300, 60, 10}
+	c.Countdown.IdleWarning = 10
+	c.Countdown.Command = "say"
+	c.Countdown.SaveBeforeStop = true
+
+	c.Wake.UseBannedPlayers = true
+
+	c.Limits.ConnectionsPerMinute = 60
+	c.Limits.ConnectionsBurst = 20
+	c.Limits.StatusPerMinute = 20
+	c.Limits.StatusBurst = 10
+	c.Limits.WakesPerMinute = 2
+	c.Limits.WakesBurst = 3
+	c.Limits.MaxConnections = 200
+	c.Limits.MaxViolations = 5
+	c.Limits.ViolationWindow = 60
+	c.Limits.BanDuration = 600
+
+	c.History.Enabled = true
+
+	c.Energy.RunningWatts = 60
+	c.Energy.IdleWatts = 15
+
+	c.Console.Enabled = true
+	c.Console.EchoServerOutput = true
+	c.Console.RconAddress = ""
+	c.Console.RconPassword = ""
+
+	c.Control.Socket = "/tmp/msh.sock"
+
+	c.Api.Enabled = false
+	c.Api.Host = "0.0.0.0"
+	c.Api.Port = "25580"
+
+	c.Hooks.DefaultTimeout = 30
+
+	c.Notifications.Events = []string{"starting", "offline", "crashed"}
+	c.Notifications.Templates = map[string]string{
+		"starting":     "{{if .Player}}{{.Player}} woke up the server{{else}}Server is starting ({{.Reason}}){{end}}",
+		"online":       "Server is online",
+		"stopping":     "Server is stopping ({{.Reason}})",
+		"offline":      "Server went to sleep ({{.Reason}}) after {{.UptimeText}}",
+		"crashed":      "Server crashed after {{.UptimeText}}",
+		"player_join":  "{{.Player}} joined the server ({{.Players}} online)",
+		"player_leave": "{{.Player}} left the server ({{.Players}} online)",
+		"wake_denied":  "{{.Player}} couldn't wake the server: {{.Reason}}",
+	}
+	c.Notifications.Retries = 3
+	c.Notifications.MinInterval = 300
+
+	c.Mqtt.Enabled = false
+	c.Mqtt.Broker = "127.0.0.1:1883"
+	c.Mqtt.ClientID = "msh"
+	c.Mqtt.TopicPrefix = "msh"
+	c.Mqtt.DiscoveryPrefix = "homeassistant"
+
+	return c
+}
+
+// loadConfiguration builds the configuration from: defaults < config file < environment variables < flags.
+// if mustExist is false a missing config file is not an error.
+func loadConfiguration(path string, mustExist bool) (*configuration, error) {
+	c, err := readConfiguration(path, mustExist)
+	if err != nil {
+		return nil, err
+	}
+
+	c.normalize()
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// readConfiguration reads the config file and applies the environment variable and flag overrides.
+// the returned configuration is not normalized nor validated
+func readConfiguration(path string, mustExist bool) (*configuration, error) {
+	c := defaultConfiguration()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) || mustExist {
+			return nil, fmt.Errorf("config: cannot read %s: %v", path, err)
+		}
+		logMsh.Warn("config file not found, using defaults", "path", path)
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(c); err != nil {
+			return nil, fmt.Errorf("config: %s: %s", path, describeJSONError(data, err))
+		}
+	}
+
+	// environment variable overrides: MSH_{SECTION}_{FIELD} (example: MSH_BASIC_MAXRAM=4G)
+	for _, fieldPath := range c.fieldPaths() {
+		envName := "MSH_" + strings.ToUpper(strings.ReplaceAll(fieldPath, ".", "_"))
+		if value, ok := os.LookupEnv(envName); ok {
+			if err := c.set(fieldPath, value); err != nil {
+				return nil, fmt.Errorf("config: environment variable %s: %v", envName, err)
+			}
+		}
+	}
+
+	// command line flag overrides
+	for fieldPath, value := range configFlags {
+		if err := c.set(fieldPath, value); err != nil {
+			return nil, fmt.Errorf("config: flag for %s: %v", fieldPath, err)
+		}
+	}
+
+	return c, nil
+}
+
+// normalize completes the configuration with values derived from other settings
+func (c *configuration) normalize() {
+	if !strings.HasSuffix(c.Basic.McPath, "/") {
+		c.Basic.McPath = c.Basic.McPath + "/"
+	}
+	if c.Api.AuditLog == "" {
+		c.Api.AuditLog = c.Basic.McPath + "msh-audit.log"
+	}
+	if c.Maintenance.FlagFile == "" {
+		c.Maintenance.FlagFile = c.Basic.McPath + "msh-maintenance"
+	}
+	if c.WakeQuota.File == "" {
+		c.WakeQuota.File = c.Basic.McPath + "msh-wake-quotas.json"
+	}
+	if c.History.File == "" {
+		c.History.File = c.Basic.McPath + "msh-history.jsonl"
+	}
+}
+
+// startCommand returns the command used to start the minecraft server
+func (c *configuration) startCommand() string {
+	if c.Basic.StartMinecraftServer != "" {
+		return c.Basic.StartMinecraftServer
+	}
+	return "cd " + c.Basic.McPath + "; screen -dmS minecraftSERVER nice -19 java -Xms" + c.Basic.MinRAM + " -Xmx" + c.Basic.MaxRAM + " -jar " + c.Basic.McFile + " nogui"
+}
+
+// validate checks that all the settings are usable. all the problems found are returned in a single error
+func (c *configuration) validate() error {
+	var problems []string
+
+	var checkPositive = func(name string, value int) {
+		if value <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be greater than 0 (got %d)", name, value))
+		}
+	}
+	var checkNotEmpty = func(name, value string) {
+		if strings.TrimSpace(value) == "" {
+			problems = append(problems, name+" must not be empty")
+		}
+	}
+	var checkPort = func(name, value string) {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("%s must be a port number between 1 and 65535 (got %q)", name, value))
+		}
+	}
+	var checkRAM = func(name, value string) {
+		trimmed := strings.TrimRight(value, "kKmMgG")
+		if _, err := strconv.Atoi(trimmed); err != nil || len(value)-len(trimmed) > 1 {
+			problems = append(problems, fmt.Sprintf("%s must be a java memory size like 512M or 2G (got %q)", name, value))
+		}
+	}
+
+	checkRAM("Basic.MinRAM", c.Basic.MinRAM)
+	checkRAM("Basic.MaxRAM", c.Basic.MaxRAM)
+	checkNotEmpty("Basic.McPath", c.Basic.McPath)
+	checkNotEmpty("Basic.McFile", c.Basic.McFile)
+	checkNotEmpty("Basic.StopMinecraftServer", c.Basic.StopMinecraftServer)
+	checkNotEmpty("Basic.SendCommandToServer", c.Basic.SendCommandToServer)
+	checkPositive("Basic.MinecraftServerStartupTime", c.Basic.MinecraftServerStartupTime)
+	checkPositive("Basic.TimeBeforeStoppingEmptyServer", c.Basic.TimeBeforeStoppingEmptyServer)
+
+	checkNotEmpty("Advanced.ListenHost", c.Advanced.ListenHost)
+	checkPort("Advanced.ListenPort", c.Advanced.ListenPort)
+	checkNotEmpty("Advanced.TargetHost", c.Advanced.TargetHost)
+	checkPort("Advanced.TargetPort", c.Advanced.TargetPort)
+	if _, err := strconv.Atoi(c.Advanced.ServerProtocol); err != nil {
+		problems = append(problems, fmt.Sprintf("Advanced.ServerProtocol must be a number (got %q)", c.Advanced.ServerProtocol))
+	}
+
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		problems = append(problems, fmt.Sprintf("Log.Format must be \"text\" or \"json\" (got %q)", c.Log.Format))
+	}
+	var checkLogLevel = func(name, value string) {
+		if _, err := parseLogLevel(value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	checkLogLevel("Log.Level", c.Log.Level)
+	for name, value := range map[string]string{"Log.ProxyLevel": c.Log.ProxyLevel, "Log.ProcessLevel": c.Log.ProcessLevel, "Log.ProtocolLevel": c.Log.ProtocolLevel} {
+		if value != "" {
+			checkLogLevel(name, value)
+		}
+	}
+
+	if c.WakeQuota.MaxPerDay < 0 || c.WakeQuota.Cooldown < 0 {
+		problems = append(problems, "WakeQuota.MaxPerDay and WakeQuota.Cooldown must not be negative")
+	}
+
+	if c.Quorum.Players < 0 {
+		problems = append(problems, fmt.Sprintf("Quorum.Players must not be negative (got %d)", c.Quorum.Players))
+	}
+	if c.Quorum.Players > 1 && c.Quorum.Window <= 0 {
+		problems = append(problems, fmt.Sprintf("Quorum.Window must be positive (got %d)", c.Quorum.Window))
+	}
+	if c.Quorum.DistinctBy != "name" && c.Quorum.DistinctBy != "ip" {
+		problems = append(problems, fmt.Sprintf("Quorum.DistinctBy must be name or ip (got %q)", c.Quorum.DistinctBy))
+	}
+
+	if _, err := time.LoadLocation(c.Schedule.Timezone); err != nil {
+		problems = append(problems, fmt.Sprintf("Schedule.Timezone: %v", err))
+	}
+	for i, window := range c.Schedule.Windows {
+		name := fmt.Sprintf("Schedule.Windows[%d]", i)
+		if !slices.Contains(scheduleModes, window.Mode) {
+			problems = append(problems, fmt.Sprintf("%s.Mode must be one of %s (got %q)", name, strings.Join(scheduleModes, ", "), window.Mode))
+		}
+		for _, days := range window.Days {
+			if _, _, err := parseScheduleDays(days); err != nil {
+				problems = append(problems, fmt.Sprintf("%s.Days: %v", name, err))
+			}
+		}
+		for field, clock := range map[string]string{"From": window.From, "Until": window.Until} {
+			if _, err := parseClock(clock); err != nil {
+				problems = append(problems, fmt.Sprintf("%s.%s: %v", name, field, err))
+			}
+		}
+	}
+
+	if c.History.RetentionDays < 0 {
+		problems = append(problems, fmt.Sprintf("History.RetentionDays must not be negative (got %d)", c.History.RetentionDays))
+	}
+	if c.History.RetentionDays > 0 && c.Prewarm.Enabled && c.History.RetentionDays < c.Prewarm.Days {
+		problems = append(problems, fmt.Sprintf("History.RetentionDays must be at least Prewarm.Days (%d)", c.Prewarm.Days))
+	}
+	if c.History.RetentionDays > 0 && c.Budget.MonthlyHours > 0 && c.History.RetentionDays < 31 {
+		problems = append(problems, "History.RetentionDays must be at least 31 with Budget.MonthlyHours")
+	}
+
+	if c.Prewarm.Enabled && !c.History.Enabled {
+		problems = append(problems, "Prewarm.Enabled needs History.Enabled")
+	}
+	if c.Prewarm.Days < 7 {
+		problems = append(problems, fmt.Sprintf("Prewarm.Days must be at least 7 (got %d)", c.Prewarm.Days))
+	}
+	if c.Prewarm.Threshold <= 0 || c.Prewarm.Threshold > 1 {
+		problems = append(problems, fmt.Sprintf("Prewarm.Threshold must be between 0 and 1 (got %v)", c.Prewarm.Threshold))
+	}
+	if c.Prewarm.Lead < 0 || c.Prewarm.Lead > 59 {
+		problems = append(problems, fmt.Sprintf("Prewarm.Lead must be between 0 and 59 minutes (got %d)", c.Prewarm.Lead))
+	}
+	if c.Prewarm.BudgetHours < 0 {
+		problems = append(problems, fmt.Sprintf("Prewarm.BudgetHours must not be negative (got %v)", c.Prewarm.BudgetHours))
+	}
+
+	if c.Budget.DailyHours < 0 || c.Budget.WeeklyHours < 0 || c.Budget.MonthlyHours < 0 {
+		problems = append(problems, "Budget.DailyHours, Budget.WeeklyHours and Budget.MonthlyHours must not be negative")
+	}
+	if (c.Budget.DailyHours > 0 || c.Budget.WeeklyHours > 0 || c.Budget.MonthlyHours > 0) && !c.History.Enabled {
+		problems = append(problems, "the Budget limits need History.Enabled")
+	}
+
+	if c.Afk.Minutes < 0 {
+		problems = append(problems, fmt.Sprintf("Afk.Minutes must not be negative (got %d)", c.Afk.Minutes))
+	}
+	if c.Afk.BytesPerMinute <= 0 {
+		problems = append(problems, fmt.Sprintf("Afk.BytesPerMinute must be positive (got %d)", c.Afk.BytesPerMinute))
+	}
+	if !slices.Contains(afkPolicies, c.Afk.Policy) {
+		problems = append(problems, fmt.Sprintf("Afk.Policy must be one of %s (got %q)", strings.Join(afkPolicies, ", "), c.Afk.Policy))
+	}
+
+	for _, seconds := range c.Countdown.Warnings {
+		if seconds <= 0 {
+			problems = append(problems, fmt.Sprintf("Countdown.Warnings must be positive (got %d)", seconds))
+		}
+	}
+	if c.Countdown.IdleWarning < 0 {
+		problems = append(problems, fmt.Sprintf("Countdown.IdleWarning must not be negative (got %d)", c.Countdown.IdleWarning))
+	}
+	if c.Countdown.Command != "say" && c.Countdown.Command != "tellraw" {
+		problems = append(problems, fmt.Sprintf("Countdown.Command must be say or tellraw (got %q)", c.Countdown.Command))
+	}
+
+	for name, list := range map[string][]string{"Wake.Allowlist": c.Wake.Allowlist, "Wake.Denylist": c.Wake.Denylist, "Maintenance.Admins": c.Maintenance.Admins, "Quorum.Admins": c.Quorum.Admins} {
+		for _, item := range list {
+			if !validPlayerName.MatchString(item) && len(normalizeUUID(item)) != 32 {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a player name or uuid", name, item))
+			}
+		}
+	}
+
+	for _, purpose := range accessPurposes {
+		rule := c.accessRule(purpose)
+		name := "Access." + strings.ToUpper(purpose[:1]) + purpose[1:]
+		for _, item := range append(slices.Clone(rule.AllowCIDRs), rule.DenyCIDRs...) {
+			if _, _, err := net.ParseCIDR(item); err != nil && net.ParseIP(item) == nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a network (example: 192.168.1.0/24) or an ip", name, item))
+			}
+		}
+		for _, country := range append(slices.Clone(rule.AllowCountries), rule.DenyCountries...) {
+			if len(country) != 2 {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a two letter country code", name, country))
+			}
+		}
+		if len(rule.AllowCountries)+len(rule.DenyCountries) > 0 && c.Access.GeoIPDatabase == "" {
+			problems = append(problems, name+": the country rules need Access.GeoIPDatabase")
+		}
+	}
+	if c.Access.GeoIPDatabase != "" {
+		if _, err := os.Stat(c.Access.GeoIPDatabase); err != nil {
+			problems = append(problems, fmt.Sprintf("Access.GeoIPDatabase: %v", err))
+		}
+	}
+
+	for name, value := range map[string]int{"Limits.ConnectionsPerMinute": c.Limits.ConnectionsPerMinute, "Limits.ConnectionsBurst": c.Limits.ConnectionsBurst,
+		"Limits.StatusPerMinute": c.Limits.StatusPerMinute, "Limits.StatusBurst": c.Limits.StatusBurst, "Limits.WakesPerMinute": c.Limits.WakesPerMinute,
+		"Limits.WakesBurst": c.Limits.WakesBurst, "Limits.MaxConnections": c.Limits.MaxConnections, "Limits.MaxViolations": c.Limits.MaxViolations} {
+		if value < 0 {
+			problems = append(problems, fmt.Sprintf("%s must not be negative (got %d)", name, value))
+		}
+	}
+	if c.Limits.MaxViolations > 0 {
+		checkPositive("Limits.ViolationWindow", c.Limits.ViolationWindow)
+		checkPositive("Limits.BanDuration", c.Limits.BanDuration)
+	}
+
+	if c.Energy.RunningWatts < 0 || c.Energy.IdleWatts < 0 {
+		problems = append(problems, "Energy.RunningWatts and Energy.IdleWatts must not be negative")
+	}
+
+	if c.Console.RconAddress != "" {
+		if _, port, err := net.SplitHostPort(c.Console.RconAddress); err != nil {
+			problems = append(problems, fmt.Sprintf("Console.RconAddress must be host:port (got %q)", c.Console.RconAddress))
+		} else {
+			checkPort("Console.RconAddress port", port)
+		}
+	}
+
+	if c.Api.Enabled {
+		checkNotEmpty("Api.Host", c.Api.Host)
+		checkPort("Api.Port", c.Api.Port)
+		if (c.Api.TLSCert == "") != (c.Api.TLSKey == "") {
+			problems = append(problems, "Api.TLSCert and Api.TLSKey must be set together")
+		}
+		if len(c.Api.Tokens) == 0 {
+			problems = append(problems, "Api.Tokens must contain at least one token when Api.Enabled is true")
+		}
+		names := map[string]bool{}
+		for i, token := range c.Api.Tokens {
+			name := fmt.Sprintf("Api.Tokens[%d]", i)
+			if token.Name == "" {
+				problems = append(problems, name+".Name must not be empty")
+			} else if names[token.Name] {
+				problems = append(problems, fmt.Sprintf("%s.Name %q is used by another token", name, token.Name))
+			}
+			names[token.Name] = true
+			if hash := strings.TrimPrefix(token.Hash, "sha256:"); len(hash) != 64 || strings.Trim(strings.ToLower(hash), "0123456789abcdef") != "" {
+				problems = append(problems, name+".Hash must be the sha256 of the token as 64 hex characters")
+			}
+			if token.Role != "read" && token.Role != "operator" {
+				problems = append(problems, fmt.Sprintf("%s.Role must be \"read\" or \"operator\" (got %q)", name, token.Role))
+			}
+		}
+	}
+
+	checkPositive("Hooks.DefaultTimeout", c.Hooks.DefaultTimeout)
+	for i, hook := range c.Hooks.Commands {
+		name := fmt.Sprintf("Hooks.Commands[%d]", i)
+		if !slices.Contains(hookEvents, hook.Event) {
+			problems = append(problems, fmt.Sprintf("%s.Event must be one of %s (got %q)", name, strings.Join(hookEvents, ", "), hook.Event))
+		}
+		checkNotEmpty(name+".Command", hook.Command)
+		if hook.Timeout < 0 {
+			problems = append(problems, fmt.Sprintf("%s.Timeout must not be negative (got %d)", name, hook.Timeout))
+		}
+		if hook.Blocking && hook.Event != "starting" && hook.Event != "stopping" {
+			problems = append(problems, fmt.Sprintf("%s.Blocking can only be used with the \"starting\" and \"stopping\" events", name))
+		}
+	}
+
+	for _, eventType := range c.Notifications.Events {
+		if !slices.Contains(hookEvents, eventType) {
+			problems = append(problems, fmt.Sprintf("Notifications.Events: unknown event %q", eventType))
+		}
+	}
+	for eventType, text := range c.Notifications.Templates {
+		if _, err := template.New(eventType).Parse(text); err != nil {
+			problems = append(problems, fmt.Sprintf("Notifications.Templates[%q]: %v", eventType, err))
+		}
+	}
+	if c.Notifications.Retries < 0 {
+		problems = append(problems, fmt.Sprintf("Notifications.Retries must not be negative (got %d)", c.Notifications.Retries))
+	}
+	if c.Notifications.MinInterval < 0 {
+		problems = append(problems, fmt.Sprintf("Notifications.MinInterval must not be negative (got %d)", c.Notifications.MinInterval))
+	}
+	sinkNames := map[string]bool{}
+	for i, sink := range c.Notifications.Sinks {
+		name := fmt.Sprintf("Notifications.Sinks[%d]", i)
+		if sink.Name == "" {
+			problems = append(problems, name+".Name must not be empty")
+		} else if sinkNames[sink.Name] {
+			problems = append(problems, fmt.Sprintf("%s.Name %q is used by another sink", name, sink.Name))
+		}
+		sinkNames[sink.Name] = true
+		if !slices.Contains(notificationSinkTypes, sink.Type) {
+			problems = append(problems, fmt.Sprintf("%s.Type must be one of %s (got %q)", name, strings.Join(notificationSinkTypes, ", "), sink.Type))
+		}
+		if sink.Type == "smtp" {
+			if _, port, err := net.SplitHostPort(sink.URL); err != nil {
+				problems = append(problems, fmt.Sprintf("%s.URL must be the mail server host:port (got %q)", name, sink.URL))
+			} else {
+				checkPort(name+".URL port", port)
+			}
+			checkNotEmpty(name+".From", sink.From)
+			if len(sink.To) == 0 {
+				problems = append(problems, name+".To must contain at least one address")
+			}
+		} else if !strings.HasPrefix(sink.URL, "http://") && !strings.HasPrefix(sink.URL, "https://") {
+			problems = append(problems, fmt.Sprintf("%s.URL must be an http(s) url (got %q)", name, sink.URL))
+		}
+		for _, eventType := range sink.Events {
+			if !slices.Contains(hookEvents, eventType) {
+				problems = append(problems, fmt.Sprintf("%s.Events: unknown event %q", name, eventType))
+			}
+		}
+	}
+
+	if c.Mqtt.Enabled {
+		if _, port, err := net.SplitHostPort(c.Mqtt.Broker); err != nil {
+			problems = append(problems, fmt.Sprintf("Mqtt.Broker must be host:port (got %q)", c.Mqtt.Broker))
+		} else {
+			checkPort("Mqtt.Broker port", port)
+		}
+		checkNotEmpty("Mqtt.ClientID", c.Mqtt.ClientID)
+		checkNotEmpty("Mqtt.TopicPrefix", c.Mqtt.TopicPrefix)
+		if strings.ContainsAny(c.Mqtt.TopicPrefix+c.Mqtt.DiscoveryPrefix, "+#") {
+			problems = append(problems, "Mqtt.TopicPrefix and Mqtt.DiscoveryPrefix must not contain the wildcards + and #")
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("config: invalid configuration:\n\t" + strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// fieldPaths returns the paths ("Section.Field") of all the settings
+func (c *configuration) fieldPaths() []string {
+	var paths []string
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Type().Field(i)
+		for j := 0; j < section.Type.NumField(); j++ {
+			paths = append(paths, section.Name+"."+section.Type.Field(j).Name)
+		}
+	}
+	return paths
+}
+
+// set parses value and stores it in the setting at fieldPath ("Section.Field")
+func (c *configuration) set(fieldPath, value string) error {
+	names := strings.SplitN(fieldPath, ".", 2)
+	if len(names) != 2 {
+		return fmt.Errorf("invalid setting name %q", fieldPath)
+	}
+	section := reflect.ValueOf(c).Elem().FieldByName(names[0])
+	if !section.IsValid() || section.Kind() != reflect.Struct {
+		return fmt.Errorf("unknown section %q", names[0])
+	}
+	field := section.FieldByName(names[1])
+	if !field.IsValid() {
+		return fmt.Errorf("unknown setting %q", fieldPath)
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		field.SetInt(number)
+	case reflect.Float64:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetFloat(number)
+	case reflect.Bool:
+		boolean, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		field.SetBool(boolean)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("setting %q can only be set in the config file", fieldPath)
+		}
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("setting %q can only be set in the config file", fieldPath)
+	}
+	return nil
+}
+
+// get returns the setting at fieldPath ("Section.Field")
+func (c *configuration) get(fieldPath string) interface{} {
+	return c.field(fieldPath).Interface()
+}
+
+// field returns the settable value of a setting ("Section.Field"). fieldPath must be a valid setting
+func (c *configuration) field(fieldPath string) reflect.Value {
+	names := strings.SplitN(fieldPath, ".", 2)
+	return reflect.ValueOf(c).Elem().FieldByName(names[0]).FieldByName(names[1])
+}
+
+// describeJSONError adds the line and column to json syntax and type errors
+func describeJSONError(data []byte, err error) string {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+	} else if errors.As(err, &typeErr) {
+		offset = typeErr.Offset
+		err = fmt.Errorf("%s must be of type %s (got %s)", typeErr.Field, typeErr.Type, typeErr.Value)
+	} else {
+		return err.Error()
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	column := offset - int64(bytes.LastIndex(data[:offset], []byte("\n")))
+	return fmt.Sprintf("line %d, column %d: %v", line, column, err)
+}
+
+// initConfiguration parses the command line flags and loads the configuration. msh exits if the configuration is invalid
+func initConfiguration() {
+	// flags used to start the MC server with Docker ENV variables (an empty value means "use config file")
+	var flagFields = map[string]string{
+		"minRAM": "Basic.MinRAM",
+		"maxRAM": "Basic.MaxRAM",
+		"mcPath": "Basic.McPath",
+		"mcFile": "Basic.McFile",
+		"debug":  "Advanced.Debug",
+	}
+	var flagValues = map[string]*string{}
+
+	defaults := defaultConfiguration()
+	flag.StringVar(&configPath, "config", "", "Specify path of the msh config file (default: {mcPath}msh-config.json).")
+	flagValues["minRAM"] = flag.String("minRAM", "", "Specify minimum amount of RAM. (default "+defaults.Basic.MinRAM+")")
+	flagValues["maxRAM"] = flag.String("maxRAM", "", "Specify maximum amount of RAM. (default "+defaults.Basic.MaxRAM+")")
+	flagValues["mcPath"] = flag.String("mcPath", "", "Specify path of Minecraft folder. (default "+defaults.Basic.McPath+")")
+	flagValues["mcFile"] = flag.String("mcFile", "", "Specify name of Minecraft .jar file (default "+defaults.Basic.McFile+")")
+	flagValues["debug"] = flag.String("debug", "", "True turns debug logging on.")
+	flag.Parse()
+
+	for name, value := range flagValues {
+		if *value != "" {
+			configFlags[flagFields[name]] = *value
+		}
+	}
+
+	var mustExist bool
+	configPath, mustExist = resolveConfigPath(configPath)
+
+	c, err := loadConfiguration(configPath, mustExist)
+	if err != nil {
+		logMsh.Error(err.Error())
+		time.Sleep(time.Duration(5) * time.Second)
+		os.Exit(1)
+	}
+	configPointer.Store(c)
+
+	serverVersion = c.Advanced.ServerVersion
+	serverProtocol = c.Advanced.ServerProtocol
+	timeLeftUntilUp = c.Basic.MinecraftServerStartupTime
+}
+
+// redactedValue replaces the secrets in the configuration shown by the api
+const redactedValue = "(redacted)"
+
+// redacted returns a copy of the configuration without the secrets (passwords, api token hashes and webhook
+// urls, that contain the webhook token). the secrets that are set are replaced with redactedValue
+func (c *configuration) redacted() *configuration {
+	var redact = func(value *string) {
+		if *value != "" {
+			*value = redactedValue
+		}
+	}
+
+	r := *c
+	redact(&r.Console.RconPassword)
+	redact(&r.Mqtt.Password)
+	r.Api.Tokens = slices.Clone(c.Api.Tokens)
+	for i := range r.Api.Tokens {
+		redact(&r.Api.Tokens[i].Hash)
+	}
+	r.Notifications.Sinks = slices.Clone(c.Notifications.Sinks)
+	for i := range r.Notifications.Sinks {
+		sink := &r.Notifications.Sinks[i]
+		redact(&sink.Password)
+		if sink.Type != "smtp" {
+			redact(&sink.URL)
+		}
+	}
+	return &r
+}
+
+// resolveConfigPath returns the path of the config file (path if not empty, then MSH_CONFIG, then msh-config.json
+// in the minecraft folder) and whether the file must exist (true if the path was specified)
+func resolveConfigPath(path string) (string, bool) {
+	if path != "" {
+		return path, true
+	}
+	if path = os.Getenv("MSH_CONFIG"); path != "" {
+		return path, true
+	}
+	mcPath := defaultConfiguration().Basic.McPath
+	if value, ok := os.LookupEnv("MSH_BASIC_MCPATH"); ok {
+		mcPath = value
+	}
+	if value, ok := configFlags["Basic.McPath"]; ok {
+		mcPath = value
+	}
+	return strings.TrimSuffix(mcPath, "/") + "/msh-config.json", false
+}
+
+// reloadConfiguration loads the config file again and applies the settings that can be changed while running.
+// if the config file is missing or the new configuration is invalid the current one is kept.
+func reloadConfiguration() error {
+	// on reload the config file must exist: the defaults never replace a running configuration
+	newConfig, err := readConfiguration(configPath, true)
+	if err != nil {
+		logMsh.Error("config reload failed, keeping current configuration", "error", err)
+		return err
+	}
+
+	oldConfig := conf()
+
+	// settings that need a restart keep the current value. they are restored before normalizing,
+	// so that the settings derived from them (like the files in Basic.McPath) don't change either
+	normalized := *newConfig
+	normalized.normalize()
+	for _, fieldPath := range configRestartFields {
+		if !reflect.DeepEqual(oldConfig.get(fieldPath), normalized.get(fieldPath)) {
+			logMsh.Warn("config reload: setting changed, restart msh to apply it", "setting", fieldPath)
+		}
+		newConfig.field(fieldPath).Set(oldConfig.field(fieldPath))
+	}
+	newConfig.normalize()
+
+	if err := newConfig.validate(); err != nil {
+		logMsh.Error("config reload failed, keeping current configuration", "error", err)
+		return err
+	}
+
+	configPointer.Store(newConfig)
+	applyLogLevels(newConfig)
+	logMsh.Info("config reloaded", "path", configPath)
+	return nil
+}
+
+// watchConfiguration reloads the configuration when msh receives SIGHUP or when the config file changes
+func watchConfiguration() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var lastModTime time.Time
+	var lastSize int64 = -1
+	if info, err := os.Stat(configPath); err == nil {
+		lastModTime, lastSize = info.ModTime(), info.Size()
+	}
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			logMsh.Info("SIGHUP received, reloading config")
+			reloadConfiguration()
+
+		case <-ticker.C:
+			info, err := os.Stat(configPath)
+			if err != nil {
+				continue
+			}
+			if info.ModTime().Equal(lastModTime) && info.Size() == lastSize {
+				continue
+			}
+			lastModTime, lastSize = info.ModTime(), info.Size()
+			logMsh.Debug("watchConfiguration: config file changed")
+			reloadConfiguration()
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useConfigFile makes the reload read a config file in a temporary directory until the test ends
+func useConfigFile(t *testing.T) string {
+	t.Helper()
+	previousPath := configPath
+	configPath = filepath.Join(t.TempDir(), "msh-config.json")
+	t.Cleanup(func() { configPath = previousPath })
+	return configPath
+}
+
+func TestReloadConfigurationKeepsRestartSettings(t *testing.T) {
+	useTestConfiguration(t, func(c *configuration) {
+		c.Basic.McPath = "/srv/old/"
+		c.Advanced.ListenPort = "25555"
+		c.normalize()
+	})
+	path := useConfigFile(t)
+
+	data := `{"Basic": {"McPath": "/srv/new", "MaxRAM": "4G"}, "Advanced": {"ListenPort": "25600"}, "History": {"Enabled": false}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfiguration(); err != nil {
+		t.Fatalf("reloadConfiguration: %v", err)
+	}
+
+	c := conf()
+	if c.Basic.MaxRAM != "4G" {
+		t.Errorf("Basic.MaxRAM = %q, want the reloaded value 4G", c.Basic.MaxRAM)
+	}
+	if c.Basic.McPath != "/srv/old/" || c.Advanced.ListenPort != "25555" {
+		t.Errorf("restart settings changed on reload: Basic.McPath = %q, Advanced.ListenPort = %q", c.Basic.McPath, c.Advanced.ListenPort)
+	}
+	// the files in Basic.McPath follow the path in use, not the one in the new config file
+	for name, file := range map[string]string{
+		"Api.AuditLog":         c.Api.AuditLog,
+		"Maintenance.FlagFile": c.Maintenance.FlagFile,
+		"WakeQuota.File":       c.WakeQuota.File,
+		"History.File":         c.History.File,
+	} {
+		if filepath.Dir(file) != "/srv/old" {
+			t.Errorf("%s = %q, want a file in /srv/old", name, file)
+		}
+	}
+}
+
+func TestReloadConfigurationMissingFile(t *testing.T) {
+	useTestConfiguration(t, func(c *configuration) { c.Basic.MaxRAM = "3G" })
+	useConfigFile(t)
+	current := conf()
+
+	if err := reloadConfiguration(); err == nil {
+		t.Error("reloadConfiguration without config file: expected an error")
+	}
+	if conf() != current {
+		t.Error("configuration replaced by the defaults after a reload without config file")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// prefix of the minecraft server output lines printed on the msh stdout
+const serverOutputPrefix = "[server] "
+
+// startConsole launches the goroutines that read the msh stdin and follow the minecraft server log
+func startConsole() {
+	if conf().Console.Enabled {
+		go readConsoleInput(os.Stdin)
+	}
+	go followServerLog()
+}
+
+// readConsoleInput sends each line read from input to the minecraft server console
+func readConsoleInput(input io.Reader) {
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		response, err := sendServerCommand(line)
+		if err != nil {
+			fmt.Println(serverOutputPrefix + "error: " + err.Error())
+			continue
+		}
+		if response != "" {
+			fmt.Println(serverOutputPrefix + strings.TrimRight(response, "\n"))
+		}
+	}
+	// stdin closed (example: docker container started without -i)
+	logMsh.Debug("readConsoleInput: stdin closed, console input disabled")
+}
+
+// followServerLog reads the new lines of the minecraft server log (logs/latest.log) and publishes them
+// as "console" events. if Console.EchoServerOutput is true they are also printed on stdout.
+// only lines written after msh started are read.
+func followServerLog() {
+	var file *os.File
+	var fileInfo os.FileInfo
+	var partial []byte
+	buffer := make([]byte, 32*1024)
+
+	// the lines already in the log when msh starts are skipped
+	skipExisting := true
+
+	for ; ; time.Sleep(500 * time.Millisecond) {
+		logPath := conf().Basic.McPath + "logs/latest.log"
+		pathInfo, err := os.Stat(logPath)
+		if err != nil {
+			skipExisting = false
+			continue
+		}
+
+		// the server creates a new latest.log each time it starts
+		if file == nil || !os.SameFile(fileInfo, pathInfo) {
+			newFile, err := os.Open(logPath)
+			if err != nil {
+				continue
+			}
+			if skipExisting {
+				newFile.Seek(0, io.SeekEnd)
+			}
+			if file != nil {
+				file.Close()
+			}
+			file, fileInfo, partial = newFile, pathInfo, nil
+		} else if offset, _ := file.Seek(0, io.SeekCurrent); pathInfo.Size() < offset {
+			// the file was truncated
+			file.Seek(0, io.SeekStart)
+			partial = nil
+		}
+
+		skipExisting = false
+
+		for {
+			dataLen, err := file.Read(buffer)
+			if dataLen > 0 {
+				partial = append(partial, buffer[:dataLen]...)
+			}
+			if err != nil || dataLen == 0 {
+				break
+			}
+		}
+
+		for {
+			index := bytes.IndexByte(partial, '\n')
+			if index < 0 {
+				break
+			}
+			line := strings.TrimRight(string(partial[:index]), "\r")
+			partial = partial[index+1:]
+
+			publishEvent(event{Type: "console", Message: line})
+			if conf().Console.EchoServerOutput {
+				fmt.Println(serverOutputPrefix + line)
+			}
+		}
+	}
+}
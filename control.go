@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// controlRequest is sent by the msh subcommands to the running msh through the control socket (one json line)
+type controlRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	Follow  bool     `json:"follow,omitempty"`
+	Force   bool     `json:"force,omitempty"`
+	Days    int      `json:"days,omitempty"`
+	Period  string   `json:"period,omitempty"`
+}
+
+// controlResponse is sent by the running msh as answer (one json line, "logs -f" sends one line for each log line)
+type controlResponse struct {
+	Error string      `json:"error,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+	Line  string      `json:"line,omitempty"`
+}
+
+// controlUsage describes the subcommands
+const controlUsage = `usage: minecraft-server-hibernation [flags]            run msh
+       minecraft-server-hibernation <command> [-socket path] [-config path]  control the running msh
+
+commands:
+  status          show the state of the minecraft server
+  start           start the minecraft server
+  stop [--force]  stop the minecraft server (without --force only if no players are online)
+  players         list the players online
+  say <message>   send a message to the players in game
+  console [cmd]   send cmd to the server console (without cmd: interactive console)
+  reload          reload the config file
+  maintenance [on|off]  show or change the maintenance mode
+  notify-test [sink]  send a test notification to the sink (all sinks if not specified)
+  history <query> [-days N]  playtime (per player), hours (busiest hours) or wakes (wakes that resulted in play)
+  report [-period day|week|month]  hours running and hibernating, cold starts, sessions and energy saved
+  quota [reset <player|*>]  show the wakes of each player or reset the wake quota of a player (*: all players)
+  prewarm         show the learned join probabilities, the prewarm budget and decisions
+  log-level [subsystem level]  show the log levels or change the level of a subsystem (msh, proxy, process, protocol)
+  logs [-f]       show the last log lines (-f: keep showing new lines)`
+
+// controlCommands contains the functions that execute the control requests (except "logs" that streams its answer)
+var controlCommands = map[string]func(req controlRequest) (interface{}, error){
+	"status": func(req controlRequest) (interface{}, error) {
+		return getStatusInfo(), nil
+	},
+	"start": func(req controlRequest) (interface{}, error) {
+		if err := startMinecraftServer(event{Reason: "control socket"}); err != nil {
+			return nil, err
+		}
+		logMsh.Info("minecraft server start requested from control socket")
+		return getStatusInfo(), nil
+	},
+	"stop": func(req controlRequest) (interface{}, error) {
+		if err := requestStopMinecraftServer(req.Force, "control socket"); err != nil {
+			return nil, err
+		}
+		logMsh.Info("minecraft server stop requested from control socket", "force", req.Force)
+		return getStatusInfo(), nil
+	},
+	"players": func(req controlRequest) (interface{}, error) {
+		return onlinePlayerNames(), nil
+	},
+	"say": func(req controlRequest) (interface{}, error) {
+		message := strings.Join(req.Args, " ")
+		if message == "" {
+			return nil, errors.New("say needs a message")
+		}
+		_, err := sendServerCommand("say " + message)
+		return nil, err
+	},
+	"console": func(req controlRequest) (interface{}, error) {
+		command := strings.Join(req.Args, " ")
+		if command == "" {
+			return nil, errors.New("console needs a command")
+		}
+		logMsh.Info("console command from control socket", "command", command)
+		return sendServerCommand(command)
+	},
+	"maintenance": func(req controlRequest) (interface{}, error) {
+		switch strings.Join(req.Args, " ") {
+		case "":
+		case "on", "off":
+			if err := setMaintenance(req.Args[0] == "on", "control socket"); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.New("maintenance needs on or off")
+		}
+		return getStatusInfo(), nil
+	},
+	"reload": func(req controlRequest) (interface{}, error) {
+		return nil, reloadConfiguration()
+	},
+	"log-level": func(req controlRequest) (interface{}, error) {
+		switch len(req.Args) {
+		case 0:
+		case 2:
+			if err := setLogLevel(req.Args[0], req.Args[1]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.New("log-level needs a subsystem and a level (example: log-level proxy debug)")
+		}
+		return getLogLevels(), nil
+	},
+	"history": func(req controlRequest) (interface{}, error) {
+		if len(req.Args) != 1 {
+			return nil, errors.New("history needs a query: playtime, hours or wakes")
+		}
+		return queryHistory(req.Args[0], req.Days)
+	},
+	"report": func(req controlRequest) (interface{}, error) {
+		return buildEnergyReport(req.Period)
+	},
+	"quota": func(req controlRequest) (interface{}, error) {
+		switch {
+		case len(req.Args) == 0:
+		case len(req.Args) == 2 && req.Args[0] == "reset":
+			if err := resetWakeQuota(req.Args[1]); err != nil {
+				return nil, err
+			}
+			logMsh.Info("wake quota reset from control socket", "player", req.Args[1])
+		default:
+			return nil, errors.New("quota needs no arguments or reset <player|*>")
+		}
+		return listWakeQuotas(), nil
+	},
+	"prewarm": func(req controlRequest) (interface{}, error) {
+		return getPrewarmInfo()
+	},
+	"notify-test": func(req controlRequest) (interface{}, error) {
+		return sendTestNotification(strings.Join(req.Args, " "))
+	},
+}
+
+//---------------------------daemon---------------------------//
+
+// startControlSocket listens on the control socket for requests of the msh subcommands
+func startControlSocket() {
+	socketPath := conf().Control.Socket
+	if socketPath == "" {
+		return
+	}
+
+	// a socket file left by a previous msh is removed, but only if no msh is listening on it
+	if _, err := os.Stat(socketPath); err == nil {
+		if conn, err := net.DialTimeout("unix", socketPath, time.Second); err == nil {
+			conn.Close()
+			logMsh.Warn("startControlSocket: another msh is listening on the control socket, control socket disabled", "path", socketPath)
+			return
+		}
+		os.Remove(socketPath)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		logMsh.Error("startControlSocket: error while opening control socket", "error", err)
+		return
+	}
+	// only the user running msh can use the control socket
+	os.Chmod(socketPath, 0600)
+
+	logMsh.Debug("control socket listening", "path", socketPath)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				logMsh.Debug("startControlSocket: error while accepting connection", "error", err)
+				return
+			}
+			go handleControlConnection(conn)
+		}
+	}()
+}
+
+// handleControlConnection reads a request from the control socket and writes the answer
+func handleControlConnection(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		logMsh.Debug("handleControlConnection: error while reading request", "error", err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	encoder := json.NewEncoder(conn)
+
+	var req controlRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		encoder.Encode(controlResponse{Error: "invalid request: " + err.Error()})
+		return
+	}
+
+	if req.Command == "logs" {
+		streamControlLogs(conn, encoder, req.Follow)
+		return
+	}
+	if req.Command == "console" && req.Follow {
+		streamControlConsole(conn, encoder)
+		return
+	}
+
+	command, ok := controlCommands[req.Command]
+	if !ok {
+		encoder.Encode(controlResponse{Error: "unknown command: " + req.Command})
+		return
+	}
+
+	data, err := command(req)
+	if err != nil {
+		encoder.Encode(controlResponse{Error: err.Error()})
+		return
+	}
+	encoder.Encode(controlResponse{Data: data})
+}
+
+// streamControlLogs writes the last log lines and, if follow is true, the new ones until the client disconnects
+func streamControlLogs(conn net.Conn, encoder *json.Encoder, follow bool) {
+	var events chan event
+	if follow {
+		var unsubscribe func()
+		events, unsubscribe = subscribeEvents("log")
+		defer unsubscribe()
+	}
+
+	for _, line := range logTail.last(50) {
+		if encoder.Encode(controlResponse{Line: line}) != nil {
+			return
+		}
+	}
+	if !follow {
+		return
+	}
+
+	// the client never writes again: a read returns only when it disconnects
+	disconnected := make(chan bool)
+	go func() {
+		conn.Read(make([]byte, 1))
+		close(disconnected)
+	}()
+
+	for {
+		select {
+		case <-disconnected:
+			return
+		case e := <-events:
+			if e.Type != "log" {
+				continue
+			}
+			if encoder.Encode(controlResponse{Line: e.Message}) != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamControlConsole writes the minecraft server output and executes the console commands
+// sent by the client (one controlRequest per line) until the client disconnects
+func streamControlConsole(conn net.Conn, encoder *json.Encoder) {
+	events, unsubscribe := subscribeEvents("console")
+	defer unsubscribe()
+
+	responses := make(chan controlResponse, 16)
+	disconnected := make(chan bool)
+	go func() {
+		defer close(disconnected)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			var req controlRequest
+			if json.Unmarshal(scanner.Bytes(), &req) != nil {
+				continue
+			}
+			command := strings.Join(req.Args, " ")
+			logMsh.Info("console command from control socket", "command", command)
+			response, err := sendServerCommand(command)
+			if err != nil {
+				responses <- controlResponse{Error: err.Error()}
+			} else if response != "" {
+				responses <- controlResponse{Line: serverOutputPrefix + strings.TrimRight(response, "\n")}
+			}
+		}
+	}()
+
+	for {
+		var res controlResponse
+		select {
+		case <-disconnected:
+			return
+		case res = <-responses:
+		case e := <-events:
+			if e.Type != "console" {
+				continue
+			}
+			res = controlResponse{Line: serverOutputPrefix + e.Message}
+		}
+		if encoder.Encode(res) != nil {
+			return
+		}
+	}
+}
+
+//---------------------------client---------------------------//
+
+// parseControlArgs parses the arguments of a subcommand (args[0] is the command) and returns the request,
+// the -socket and the -config values. the flags can also follow the positional arguments (example: history playtime -days 7),
+// except for say and console whose arguments are sent as they are. the arguments after "--" are never flags
+func parseControlArgs(args []string) (req controlRequest, socketPath string, configFile string, err error) {
+	req = controlRequest{Command: args[0]}
+
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.StringVar(&socketPath, "socket", "", "path of the msh control socket (default: Control.Socket of the config file)")
+	flags.StringVar(&configFile, "config", "", "path of the msh config file (default: {mcPath}msh-config.json)")
+	flags.BoolVar(&req.Force, "force", false, "stop the server even if players are online")
+	flags.BoolVar(&req.Follow, "f", false, "keep showing new log lines")
+	flags.IntVar(&req.Days, "days", 0, "only the last days of history (0: all)")
+	flags.StringVar(&req.Period, "period", "day", "report grouped by day, week or month")
+	flags.Usage = func() { fmt.Fprintln(flags.Output(), controlUsage) }
+
+	rest, verbatim := args[1:], []string{}
+	if i := slices.Index(rest, "--"); i >= 0 {
+		rest, verbatim = rest[:i], rest[i+1:]
+	}
+	// flag.Parse stops at the first positional argument: it is taken and the parsing continues after it
+	for {
+		if err := flags.Parse(rest); err != nil {
+			return req, "", "", err
+		}
+		rest = flags.Args()
+		if len(rest) == 0 || req.Command == "say" || req.Command == "console" {
+			req.Args = append(req.Args, rest...)
+			break
+		}
+		req.Args = append(req.Args, rest[0])
+		rest = rest[1:]
+	}
+	req.Args = append(req.Args, verbatim...)
+	return req, socketPath, configFile, nil
+}
+
+// runControlCommand executes a msh subcommand by sending it to the running msh and returns the exit code
+func runControlCommand(args []string) int {
+	if args[0] == "help" {
+		fmt.Println(controlUsage)
+		return 0
+	}
+	if _, ok := controlCommands[args[0]]; !ok && args[0] != "logs" {
+		fmt.Fprintln(os.Stderr, "unknown command: "+args[0]+"\n"+controlUsage)
+		return 2
+	}
+
+	req, socketPath, configFile, err := parseControlArgs(args)
+	if err != nil {
+		return 2
+	}
+
+	// console without command: interactive console
+	if req.Command == "console" && len(req.Args) == 0 {
+		req.Follow = true
+	}
+
+	if socketPath == "" {
+		socketPath = defaultControlSocket(configFile)
+	}
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot connect to msh on %s: %v\n", socketPath, err)
+		return 1
+	}
+	defer conn.Close()
+
+	encoder := json.NewEncoder(conn)
+	if err := encoder.Encode(req); err != nil {
+		fmt.Fprintf(os.Stderr, "error while sending command: %v\n", err)
+		return 1
+	}
+
+	// interactive console: each line typed is sent as a console command
+	interactive := req.Command == "console" && req.Follow
+	if interactive {
+		fmt.Fprintln(os.Stderr, "connected to the minecraft server console (ctrl+c to exit)")
+		go func() {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				if line := strings.TrimSpace(scanner.Text()); line != "" {
+					encoder.Encode(controlRequest{Command: "console", Args: []string{line}})
+				}
+			}
+			conn.Close()
+		}()
+	}
+
+	decoder := json.NewDecoder(conn)
+	for {
+		var res controlResponse
+		if err := decoder.Decode(&res); err != nil {
+			// the daemon closes the connection after the last answer
+			return 0
+		}
+		if res.Error != "" {
+			fmt.Fprintln(os.Stderr, "error: "+res.Error)
+			if interactive {
+				continue
+			}
+			return 1
+		}
+		if res.Line != "" {
+			fmt.Println(res.Line)
+		}
+		if res.Data != nil {
+			printControlData(req.Command, res.Data)
+		}
+	}
+}
+
+// printControlData prints the data answered by the running msh in a readable format
+func printControlData(command string, data interface{}) {
+	switch command {
+	case "status", "start", "stop", "maintenance":
+		status, _ := data.(map[string]interface{})
+		fmt.Printf("state:   %v\n", status["state"])
+		if status["maintenance"] == true {
+			fmt.Println("maintenance: on")
+		}
+		if status["schedule"] == "closed" {
+			fmt.Printf("schedule: closed until %v\n", status["nextOpening"])
+		} else if status["schedule"] == "always_on" {
+			fmt.Println("schedule: always on")
+		}
+		if votes, ok := status["quorumVotes"]; ok {
+			fmt.Printf("quorum:  %v players waiting\n", votes)
+		}
+		budgets, _ := status["budget"].([]interface{})
+		for _, b := range budgets {
+			budget, _ := b.(map[string]interface{})
+			reset, _ := time.Parse(time.RFC3339, fmt.Sprint(budget["reset"]))
+			fmt.Printf("budget:  %v %.1f/%.1fh (resets %s)\n", budget["period"], budget["usedHours"], budget["limitHours"], reset.Local().Format("Mon 2 Jan 15:04"))
+		}
+		if status["state"] == "starting" {
+			fmt.Printf("eta:     %vs\n", status["eta"])
+		}
+		if status["state"] != "offline" {
+			fmt.Printf("uptime:  %vs\n", status["uptime"])
+		}
+		fmt.Printf("players: %v %v\n", status["players"], status["playerNames"])
+		if afk, ok := status["afkPlayers"]; ok {
+			fmt.Printf("afk:     %v\n", afk)
+		}
+		fmt.Printf("version: msh %v, server %v (protocol %v)\n", status["version"], status["serverVersion"], status["serverProtocol"])
+	case "console":
+		fmt.Println(serverOutputPrefix + strings.TrimRight(fmt.Sprint(data), "\n"))
+	case "report":
+		printEnergyReport(data)
+	case "prewarm":
+		printPrewarmInfo(data)
+	case "players":
+		names, _ := data.([]interface{})
+		fmt.Printf("%d players online\n", len(names))
+		for _, name := range names {
+			fmt.Println("  " + fmt.Sprint(name))
+		}
+	default:
+		output, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			fmt.Println(data)
+			return
+		}
+		fmt.Println(string(output))
+	}
+}
+
+// defaultControlSocket returns the control socket path used by the subcommands if -socket is not specified:
+// Control.Socket of the config file that msh loads (configFile, MSH_CONFIG or {mcPath}msh-config.json),
+// MSH_CONTROL_SOCKET included
+func defaultControlSocket(configFile string) string {
+	path, mustExist := resolveConfigPath(configFile)
+	if _, err := os.Stat(path); err != nil && !mustExist {
+		// no config file: the daemon uses the default socket (or MSH_CONTROL_SOCKET)
+		if socketPath, ok := os.LookupEnv("MSH_CONTROL_SOCKET"); ok {
+			return socketPath
+		}
+		return defaultConfiguration().Control.Socket
+	}
+	c, err := loadConfiguration(path, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v (using the default control socket)\n", err)
+		return defaultConfiguration().Control.Socket
+	}
+	return c.Control.Socket
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestParseControlArgs(t *testing.T) {
+	tests := []struct {
+		args       []string
+		want       controlRequest
+		wantSocket string
+	}{
+		// flags before and after the positional arguments
+		{[]string{"history", "-days", "7", "playtime"}, controlRequest{Command: "history", Args: []string{"playtime"}, Days: 7}, ""},
+		{[]string{"history", "playtime", "-days", "7"}, controlRequest{Command: "history", Args: []string{"playtime"}, Days: 7}, ""},
+		{[]string{"history", "hours", "-days=3", "-socket", "/tmp/a.sock"}, controlRequest{Command: "history", Args: []string{"hours"}, Days: 3}, "/tmp/a.sock"},
+		{[]string{"stop", "--force"}, controlRequest{Command: "stop", Force: true}, ""},
+		{[]string{"logs", "-f"}, controlRequest{Command: "logs", Follow: true}, ""},
+		{[]string{"report", "-period", "week"}, controlRequest{Command: "report", Period: "week"}, ""},
+		{[]string{"quota", "reset", "-socket", "/tmp/b.sock", "Steve"}, controlRequest{Command: "quota", Args: []string{"reset", "Steve"}}, "/tmp/b.sock"},
+		{[]string{"log-level", "proxy", "debug"}, controlRequest{Command: "log-level", Args: []string{"proxy", "debug"}}, ""},
+		// the arguments of say and console are sent as they are after the first one
+		{[]string{"say", "-socket", "/tmp/c.sock", "hello", "-days", "7"}, controlRequest{Command: "say", Args: []string{"hello", "-days", "7"}}, "/tmp/c.sock"},
+		{[]string{"console", "kick", "Steve", "-f"}, controlRequest{Command: "console", Args: []string{"kick", "Steve", "-f"}}, ""},
+		// the arguments after "--" are never flags
+		{[]string{"quota", "reset", "--", "-days"}, controlRequest{Command: "quota", Args: []string{"reset", "-days"}}, ""},
+	}
+	for _, test := range tests {
+		req, socketPath, _, err := parseControlArgs(test.args)
+		if err != nil {
+			t.Errorf("parseControlArgs(%q): %v", test.args, err)
+			continue
+		}
+		if test.want.Period == "" {
+			test.want.Period = "day"
+		}
+		if req.Command != test.want.Command || !slices.Equal(req.Args, test.want.Args) || req.Days != test.want.Days ||
+			req.Force != test.want.Force || req.Follow != test.want.Follow || req.Period != test.want.Period {
+			t.Errorf("parseControlArgs(%q) = %+v, want %+v", test.args, req, test.want)
+		}
+		if socketPath != test.wantSocket {
+			t.Errorf("parseControlArgs(%q) socket = %q, want %q", test.args, socketPath, test.wantSocket)
+		}
+	}
+}
+
+func TestParseControlArgsErrors(t *testing.T) {
+	// the usage printed for the invalid flags is not interesting here
+	stderr := os.Stderr
+	os.Stderr, _ = os.Open(os.DevNull)
+	defer func() { os.Stderr = stderr }()
+
+	for _, args := range [][]string{
+		{"history", "playtime", "-days", "seven"},
+		{"history", "-unknown", "playtime"},
+		{"stop", "now", "-force=maybe"},
+	} {
+		if _, _, _, err := parseControlArgs(args); err == nil {
+			t.Errorf("parseControlArgs(%q): expected an error", args)
+		}
+	}
+}
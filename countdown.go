@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// true while a stop countdown is running (only one at a time)
+var countdownRunning atomic.Bool
+
+// true after the players were warned that the empty server is being stopped (protected by mutex)
+var idleWarningSent bool
+
+// broadcastStopMessage sends message to the players in game with Countdown.Command ("say" or "tellraw")
+func broadcastStopMessage(message string) {
+	command := "say " + message
+	if conf().Countdown.Command == "tellraw" {
+		text, _ := json.Marshal(map[string]string{"text": message, "color": "gold"})
+		command = "tellraw @a " + string(text)
+	}
+	if _, err := sendServerCommand(command); err != nil {
+		logMsh.Warn("broadcastStopMessage: error while warning the players", "error", err)
+	}
+}
+
+// broadcastStopWarning tells the players that the server stops in left (now if left is 0)
+func broadcastStopWarning(left time.Duration, reason string) {
+	message := conf().Messages.StopNow
+	if left > 0 {
+		message = strings.ReplaceAll(conf().Messages.StopCountdown, "{time}", shortDuration(left))
+	}
+	broadcastStopMessage(strings.ReplaceAll(message, "{reason}", reason))
+}
+
+// stopWithCountdown stops the server at stopAt, warning the players online Countdown.Warnings seconds before it.
+// if nobody is online the server is stopped immediately. before each warning and before the stop stillNeeded
+// is checked (nil: always needed) and if it returns false the countdown is cancelled.
+// if forceExec is false the blocking "stopping" hooks can prevent the stop: it is tried again every
+// {TimeBeforeStoppingEmptyServer} seconds until it succeeds or it is not needed anymore.
+// returns false if another countdown is running
+func stopWithCountdown(stopAt time.Time, reason string, forceExec bool, stillNeeded func() bool) bool {
+	if countdownRunning.Swap(true) {
+		return false
+	}
+	defer countdownRunning.Store(false)
+
+	var cancelled = func() bool {
+		if serverStatus == "offline" || (stillNeeded != nil && !stillNeeded()) {
+			logMsh.Info("stop countdown cancelled", "reason", reason, "state", serverStatus)
+			return true
+		}
+		return false
+	}
+
+	if players > 0 {
+		logMsh.Info("stop countdown started", "reason", reason, "stop_at", stopAt.Format(time.TimeOnly), "players", players)
+
+		// the warnings are sent from the longest to the shortest (the ones already passed are skipped)
+		warnings := slices.Clone(conf().Countdown.Warnings)
+		slices.Sort(warnings)
+		slices.Reverse(warnings)
+		for _, seconds := range warnings {
+			warnAt := stopAt.Add(-time.Duration(seconds) * time.Second)
+			if time.Until(warnAt) < -time.Second {
+				continue
+			}
+			time.Sleep(time.Until(warnAt))
+			if cancelled() {
+				return true
+			}
+			broadcastStopWarning(time.Duration(seconds)*time.Second, reason)
+		}
+		time.Sleep(time.Until(stopAt))
+		if cancelled() {
+			return true
+		}
+		broadcastStopWarning(0, reason)
+	} else if cancelled() {
+		return true
+	}
+
+	for {
+		err := stopMinecraftServer(forceExec, reason, stillNeeded)
+		if !errors.Is(err, errStopBlocked) {
+			return true
+		}
+		// a blocking "stopping" hook prevented the stop: try again after {TimeBeforeStoppingEmptyServer}
+		time.Sleep(time.Duration(conf().Basic.TimeBeforeStoppingEmptyServer) * time.Second)
+		if cancelled() {
+			return true
+		}
+	}
+}
+
+// countdownDuration returns the longest warning of the countdown
+func countdownDuration() time.Duration {
+	if len(conf().Countdown.Warnings) == 0 {
+		return 0
+	}
+	return time.Duration(slices.Max(conf().Countdown.Warnings)) * time.Second
+}
+
+// saveWorld runs "save-all" before the server is stopped (if Countdown.SaveBeforeStop is true)
+func saveWorld() {
+	if !conf().Countdown.SaveBeforeStop || serverStatus != "online" {
+		return
+	}
+	logMsh.Info("saving the world before stopping the minecraft server")
+	if _, err := sendServerCommand("save-all"); err != nil {
+		logMsh.Warn("saveWorld: error while saving the world", "error", err)
+	}
+}
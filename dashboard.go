@@ -0,0 +1,117 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// dashboardPage is the single page web dashboard served on /
+//
+//go:embed web/dashboard.html
+var dashboardPage []byte
+
+// dashboardData is the answer to GET /dashboard/data
+type dashboardData struct {
+	Status         statusInfo    `json:"status"`
+	Sessions       []session     `json:"sessions"`
+	RecentSessions []session     `json:"recentSessions"`
+	Timeline       []stateChange `json:"timeline"`
+	Logs           []string      `json:"logs"`
+}
+
+// addDashboardRoutes adds the dashboard endpoints to mux
+func addDashboardRoutes(mux *http.ServeMux) {
+	// the page itself contains no data: it asks for a token and uses it to call the other endpoints
+	mux.HandleFunc("GET /{$}", dashboardIndex)
+	mux.HandleFunc("GET /dashboard/data", requireRole("read", apiDashboardData))
+	mux.HandleFunc("GET /timeline", requireRole("read", apiTimeline))
+	mux.HandleFunc("GET /logs", requireRole("read", apiLogs))
+	mux.HandleFunc("GET /sessions/recent", requireRole("read", apiRecentSessions))
+	mux.HandleFunc("GET /events", requireRole("read", apiEvents))
+}
+
+// dashboardIndex serves the dashboard page
+func dashboardIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; img-src 'self' data:")
+	w.Write(dashboardPage)
+}
+
+// apiDashboardData answers with everything the dashboard needs to be drawn
+func apiDashboardData(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, dashboardData{
+		Status:         getStatusInfo(),
+		Sessions:       listSessions(),
+		RecentSessions: listRecentSessions(),
+		Timeline:       getTimeline(),
+		Logs:           logTail.last(200),
+	})
+}
+
+// apiTimeline answers with the state changes of the last 7 days
+func apiTimeline(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, getTimeline())
+}
+
+// apiLogs answers with the last log lines (?lines=N, default 100)
+func apiLogs(w http.ResponseWriter, r *http.Request) {
+	lines := 100
+	if linesString := r.URL.Query().Get("lines"); linesString != "" {
+		var err error
+		lines, err = strconv.Atoi(linesString)
+		if err != nil || lines <= 0 {
+			writeError(w, http.StatusBadRequest, "lines must be a positive number")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, logTail.last(lines))
+}
+
+// apiRecentSessions answers with the last closed sessions
+func apiRecentSessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, listRecentSessions())
+}
+
+// apiEvents streams the msh events as server-sent events until the client disconnects
+func apiEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	events, unsubscribe := subscribeEvents()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// keeps the connection alive through proxies
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+
+		case e := <-events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
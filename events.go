@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// event is something that happened in msh.
+// Type is one of: "starting", "online", "offline" (server state changes), "stopping" (stop command about to be issued),
+// "crashed" (server stopped answering while online), "player_join", "player_leave", "wake_denied" (a start was refused),
+// "log" (msh log line), "console" (minecraft server output line)
+type event struct {
+	Type          string    `json:"type"`
+	Time          time.Time `json:"time"`
+	Player        string    `json:"player,omitempty"`
+	ClientAddress string    `json:"clientAddress,omitempty"`
+	Message       string    `json:"message,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	Uptime        int       `json:"uptime,omitempty"`
+}
+
+// stateChange is a serverStatus transition kept for the timeline
+type stateChange struct {
+	State string    `json:"state"`
+	Time  time.Time `json:"time"`
+}
+
+// eventSubscriber receives the published events of the types it subscribed to
+type eventSubscriber struct {
+	// event types received (empty: all)
+	types   []string
+	channel chan event
+	// lossless subscribers never lose events: they are queued until the subscriber receives them
+	lossless bool
+	queue    []event
+	queued   chan bool
+}
+
+// subscribers of the event stream
+var eventSubscribers = map[chan event]*eventSubscriber{}
+var eventMutex = &sync.Mutex{}
+
+// events lost by the subscribers that were not keeping up
+var eventsDropped atomic.Int64
+
+// to keep track of the state changes of the last {timelineDuration}
+var timeline = []stateChange{{State: "offline", Time: time.Now()}}
+var timelineMutex = &sync.Mutex{}
+
+const timelineDuration = 7 * 24 * time.Hour
+
+// publishEvent sends e to the subscribers of its type. lossy subscribers that are not keeping up lose the event
+// (counted in eventsDropped), lossless subscribers receive it later
+func publishEvent(e event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if e.Type == "starting" || e.Type == "online" || e.Type == "offline" {
+		timelineMutex.Lock()
+		timeline = append(timeline, stateChange{State: e.Type, Time: e.Time})
+		for len(timeline) > 1 && time.Since(timeline[1].Time) > timelineDuration {
+			timeline = timeline[1:]
+		}
+		timelineMutex.Unlock()
+	}
+
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+	for _, subscriber := range eventSubscribers {
+		if len(subscriber.types) > 0 && !slices.Contains(subscriber.types, e.Type) {
+			continue
+		}
+		if subscriber.lossless {
+			subscriber.queue = append(subscriber.queue, e)
+			select {
+			case subscriber.queued <- true:
+			default:
+			}
+			continue
+		}
+		select {
+		case subscriber.channel <- e:
+		default:
+			eventsDropped.Add(1)
+		}
+	}
+}
+
+// subscribeEvents returns a channel that receives the events of the specified types (all if none is specified)
+// published from now on. events are lost if the channel is not read fast enough.
+// unsubscribe must be called when the channel is not used anymore
+func subscribeEvents(types ...string) (chan event, func()) {
+	subscriber := &eventSubscriber{types: types, channel: make(chan event, 64)}
+
+	eventMutex.Lock()
+	eventSubscribers[subscriber.channel] = subscriber
+	eventMutex.Unlock()
+
+	unsubscribe := func() {
+		eventMutex.Lock()
+		delete(eventSubscribers, subscriber.channel)
+		eventMutex.Unlock()
+	}
+	return subscriber.channel, unsubscribe
+}
+
+// subscribeEventsLossless returns a channel that receives all the events of the specified types published from now on,
+// also when it is not read for a while (the events are queued without blocking publishEvent).
+// it is used by the hooks and the notifications, that must see every state change
+func subscribeEventsLossless(types ...string) chan event {
+	subscriber := &eventSubscriber{types: types, channel: make(chan event), lossless: true, queued: make(chan bool, 1)}
+
+	eventMutex.Lock()
+	eventSubscribers[subscriber.channel] = subscriber
+	eventMutex.Unlock()
+
+	go func() {
+		for range subscriber.queued {
+			eventMutex.Lock()
+			pending := subscriber.queue
+			subscriber.queue = nil
+			eventMutex.Unlock()
+
+			for _, e := range pending {
+				subscriber.channel <- e
+			}
+		}
+	}()
+	return subscriber.channel
+}
+
+// getTimeline returns a copy of the state changes of the last {timelineDuration}
+func getTimeline() []stateChange {
+	timelineMutex.Lock()
+	defer timelineMutex.Unlock()
+	return append([]stateChange{}, timeline...)
+}
+
+//--------------------------log tail--------------------------//
+
+// logTailLength is the number of log lines kept in memory
+const logTailLength = 500
+
+// logTail keeps the last log lines and publishes each new line as a "log" event.
+// it is used as additional output of the log package
+type logTailWriter struct {
+	mutex   sync.Mutex
+	lines   []string
+	partial []byte
+}
+
+var logTail = &logTailWriter{}
+
+func (l *logTailWriter) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	l.partial = append(l.partial, p...)
+	var newLines []string
+	for {
+		index := bytes.IndexByte(l.partial, '\n')
+		if index < 0 {
+			break
+		}
+		newLines = append(newLines, string(l.partial[:index]))
+		l.partial = l.partial[index+1:]
+	}
+	l.lines = append(l.lines, newLines...)
+	if len(l.lines) > logTailLength {
+		l.lines = l.lines[len(l.lines)-logTailLength:]
+	}
+	l.mutex.Unlock()
+
+	for _, line := range newLines {
+		publishEvent(event{Type: "log", Message: line})
+	}
+	return len(p), nil
+}
+
+// last returns the last n log lines
+func (l *logTailWriter) last(n int) []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if n <= 0 || n > len(l.lines) {
+		n = len(l.lines)
+	}
+	return append([]string{}, l.lines[len(l.lines)-n:]...)
+}
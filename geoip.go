@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// mmdbMetadataMarker precedes the metadata at the end of a MaxMind database file
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdbDatabase is a MaxMind format (.mmdb) database loaded in memory
+type mmdbDatabase struct {
+	path       string
+	modTime    time.Time
+	data       []byte
+	nodeCount  uint64
+	recordSize uint64
+	ipVersion  uint64
+	// start of the data section in data
+	dataStart int
+	// node from which the ipv4 addresses are searched (ipv4 addresses are ::a.b.c.d in an ipv6 database)
+	ipv4Start uint64
+}
+
+// mmdbMaxDepth is how deep the maps, arrays and pointers of a value can be nested
+// (the data of a country database is nested 3 levels, a pointer cycle would be infinite)
+const mmdbMaxDepth = 32
+
+// database used by the country rules (loaded when needed and reloaded when the file changes)
+var geoipDatabase *mmdbDatabase
+var geoipMutex = &sync.Mutex{}
+
+// errGeoipUnavailable is returned by lookupCountry when the database can't be loaded (already logged by the load)
+var errGeoipUnavailable = errors.New("geoip database not available")
+
+// last failed load of the database: the file is not read again (and the error is not logged again) until it changes
+var geoipFailure struct {
+	path    string
+	modTime time.Time
+	err     error
+}
+
+// lookupCountry returns the iso code of the country of ip ("" if not found) using Access.GeoIPDatabase
+func lookupCountry(ip net.IP) (string, error) {
+	db, err := loadGeoipDatabase(conf().Access.GeoIPDatabase)
+	if err != nil {
+		return "", err
+	}
+
+	record, err := db.lookup(ip)
+	if err != nil || record == nil {
+		return "", err
+	}
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := record[key].(map[string]interface{}); ok {
+			if isoCode, ok := country["iso_code"].(string); ok {
+				return isoCode, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// loadGeoipDatabase returns the database at path, reading it again if it changed since the last load.
+// a failed load is logged once and returns errGeoipUnavailable until the file changes
+func loadGeoipDatabase(path string) (*mmdbDatabase, error) {
+	geoipMutex.Lock()
+	defer geoipMutex.Unlock()
+
+	var modTime time.Time
+	info, err := os.Stat(path)
+	if err == nil {
+		modTime = info.ModTime()
+		if geoipDatabase != nil && geoipDatabase.path == path && geoipDatabase.modTime.Equal(modTime) {
+			return geoipDatabase, nil
+		}
+	}
+	if geoipFailure.err != nil && geoipFailure.path == path && geoipFailure.modTime.Equal(modTime) {
+		return nil, geoipFailure.err
+	}
+
+	var fail = func(err error) (*mmdbDatabase, error) {
+		logMsh.Warn("geoip database not available, the country rules only match the CIDR lists", "path", path, "error", err)
+		geoipFailure.path, geoipFailure.modTime = path, modTime
+		geoipFailure.err = fmt.Errorf("%w: %v", errGeoipUnavailable, err)
+		return nil, geoipFailure.err
+	}
+	if err != nil {
+		return fail(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fail(err)
+	}
+	db, err := parseMmdb(data)
+	if err != nil {
+		return fail(err)
+	}
+	db.path, db.modTime = path, modTime
+	geoipDatabase = db
+	geoipFailure.err = nil
+	logMsh.Info("geoip database loaded", "path", path, "nodes", db.nodeCount, "ip_version", db.ipVersion)
+	return db, nil
+}
+
+// parseMmdb reads the metadata of a MaxMind database and prepares it for the lookups
+func parseMmdb(data []byte) (*mmdbDatabase, error) {
+	markerIndex := bytes.LastIndex(data, mmdbMetadataMarker)
+	if markerIndex < 0 {
+		return nil, errors.New("not a MaxMind database (metadata not found)")
+	}
+	metadataStart := markerIndex + len(mmdbMetadataMarker)
+	value, _, err := decodeMmdbValue(data[metadataStart:], 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %v", err)
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid metadata")
+	}
+
+	db := &mmdbDatabase{data: data}
+	db.nodeCount, _ = metadata["node_count"].(uint64)
+	db.recordSize, _ = metadata["record_size"].(uint64)
+	db.ipVersion, _ = metadata["ip_version"].(uint64)
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported ip version %d", db.ipVersion)
+	}
+	// the search tree (recordSize/4 bytes per node) is followed by 16 zero bytes and by the data section
+	if db.nodeCount > uint64(markerIndex)/(db.recordSize/4) {
+		return nil, errors.New("search tree larger than the file")
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > uint64(markerIndex) {
+		return nil, errors.New("search tree larger than the file")
+	}
+	db.dataStart = int(treeSize) + 16
+
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.readRecord(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// lookup returns the data record of ip (nil if ip is not in the database)
+func (db *mmdbDatabase) lookup(ip net.IP) (map[string]interface{}, error) {
+	node, bits := uint64(0), ip.To16()
+	if ipv4 := ip.To4(); ipv4 != nil {
+		node, bits = db.ipv4Start, ipv4
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		node = db.readRecord(node, int(bits[i/8]>>(7-i%8)&1))
+	}
+	if node <= db.nodeCount {
+		// node == nodeCount: not found
+		return nil, nil
+	}
+
+	offset := int(node-db.nodeCount) - 16
+	if offset < 0 || db.dataStart+offset >= len(db.data) {
+		return nil, errors.New("invalid data pointer in search tree")
+	}
+	value, _, err := decodeMmdbValue(db.data[db.dataStart:], offset)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]interface{})
+	return record, nil
+}
+
+// readRecord returns the left (bit 0) or right (bit 1) record of node (node must be less than nodeCount)
+func (db *mmdbDatabase) readRecord(node uint64, bit int) uint64 {
+	nodeBytes := db.data[node*db.recordSize/4 : (node+1)*db.recordSize/4]
+	switch db.recordSize {
+	case 24:
+		return uint64(nodeBytes[bit*3])<<16 | uint64(nodeBytes[bit*3+1])<<8 | uint64(nodeBytes[bit*3+2])
+	case 28:
+		if bit == 0 {
+			return uint64(nodeBytes[3]&0xF0)<<20 | uint64(nodeBytes[0])<<16 | uint64(nodeBytes[1])<<8 | uint64(nodeBytes[2])
+		}
+		return uint64(nodeBytes[3]&0x0F)<<24 | uint64(nodeBytes[4])<<16 | uint64(nodeBytes[5])<<8 | uint64(nodeBytes[6])
+	default:
+		return uint64(binary.BigEndian.Uint32(nodeBytes[bit*4:]))
+	}
+}
+
+// decodeMmdbValue decodes the value at offset of section (data section or metadata).
+// returns the value and the offset after it. integers are returned as uint64 (int32 as int64)
+func decodeMmdbValue(section []byte, offset int) (interface{}, int, error) {
+	return decodeMmdbValueDepth(section, offset, 0)
+}
+
+// decodeMmdbValueDepth decodes a value nested depth levels in maps, arrays and pointers
+func decodeMmdbValueDepth(section []byte, offset, depth int) (interface{}, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, offset, errors.New("values nested too deep (pointer cycle?)")
+	}
+	if offset < 0 || offset >= len(section) {
+		return nil, offset, errors.New("offset out of the data section")
+	}
+	var readBytes = func(n int) ([]byte, error) {
+		if n < 0 || offset+n > len(section) {
+			return nil, errors.New("unexpected end of data")
+		}
+		b := section[offset : offset+n]
+		offset += n
+		return b, nil
+	}
+	var readUint = func(b []byte) uint64 {
+		var value uint64
+		for _, c := range b {
+			value = value<<8 | uint64(c)
+		}
+		return value
+	}
+
+	control, err := readBytes(1)
+	if err != nil {
+		return nil, offset, err
+	}
+	dataType := int(control[0] >> 5)
+
+	// pointer to another value of the section (the size bits are part of the pointer)
+	if dataType == 1 {
+		pointerSize := int(control[0]>>3&0x3) + 1
+		b, err := readBytes(pointerSize)
+		if err != nil {
+			return nil, offset, err
+		}
+		pointer := readUint(b)
+		if pointerSize < 4 {
+			pointer |= uint64(control[0]&0x7) << (8 * pointerSize)
+		}
+		pointer += []uint64{0, 2048, 526336, 0}[pointerSize-1]
+		if pointer >= uint64(len(section)) {
+			return nil, offset, errors.New("pointer out of the data section")
+		}
+		// a pointer can't point to another pointer
+		if section[pointer]>>5 == 1 {
+			return nil, offset, errors.New("pointer to a pointer")
+		}
+		value, _, err := decodeMmdbValueDepth(section, int(pointer), depth+1)
+		return value, offset, err
+	}
+
+	// extended type
+	if dataType == 0 {
+		b, err := readBytes(1)
+		if err != nil {
+			return nil, offset, err
+		}
+		dataType = 7 + int(b[0])
+	}
+
+	size := int(control[0] & 0x1F)
+	if size >= 29 {
+		b, err := readBytes(size - 28)
+		if err != nil {
+			return nil, offset, err
+		}
+		size = []int{29, 285, 65821}[size-29] + int(readUint(b))
+	}
+	// every entry of a map or array takes at least a byte: a larger size is not allocated
+	if (dataType == 7 || dataType == 11) && size > len(section)-offset {
+		return nil, offset, errors.New("unexpected end of data")
+	}
+
+	switch dataType {
+	case 2: // utf8 string
+		b, err := readBytes(size)
+		return string(b), offset, err
+	case 3: // double
+		b, err := readBytes(size)
+		if err != nil || size != 8 {
+			return nil, offset, errors.New("invalid double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 4: // bytes
+		b, err := readBytes(size)
+		return b, offset, err
+	case 5, 6, 9, 10: // uint16, uint32, uint64, uint128 (only the last 8 bytes are kept)
+		b, err := readBytes(size)
+		if len(b) > 8 {
+			b = b[len(b)-8:]
+		}
+		return readUint(b), offset, err
+	case 8: // int32
+		b, err := readBytes(size)
+		return int64(int32(readUint(b))), offset, err
+	case 7: // map
+		values := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			key, next, err := decodeMmdbValueDepth(section, offset, depth+1)
+			if err != nil {
+				return nil, next, err
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, next, errors.New("map key is not a string")
+			}
+			values[keyString], offset, err = decodeMmdbValueDepth(section, next, depth+1)
+			if err != nil {
+				return nil, offset, err
+			}
+		}
+		return values, offset, nil
+	case 11: // array
+		values := make([]interface{}, size)
+		for i := range values {
+			values[i], offset, err = decodeMmdbValueDepth(section, offset, depth+1)
+			if err != nil {
+				return nil, offset, err
+			}
+		}
+		return values, offset, nil
+	case 14: // boolean
+		return size != 0, offset, nil
+	case 15: // float
+		b, err := readBytes(size)
+		if err != nil || size != 4 {
+			return nil, offset, errors.New("invalid float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	}
+	return nil, offset, fmt.Errorf("unsupported data type %d", dataType)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mmdbString encodes a short utf8 string (less than 29 bytes) of the mmdb data section
+func mmdbString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+// mmdbUint32 encodes a uint32 of the mmdb data section
+func mmdbUint32(value uint32) []byte {
+	return binary.BigEndian.AppendUint32([]byte{6<<5 | 4}, value)
+}
+
+// mmdbMap encodes a map with less than 29 entries (keys and values already encoded, alternated)
+func mmdbMap(entries ...[]byte) []byte {
+	return append([]byte{7<<5 | byte(len(entries)/2)}, bytes.Join(entries, nil)...)
+}
+
+// mmdbPointer encodes a pointer to an offset (less than 2048) of the data section
+func mmdbPointer(offset int) []byte {
+	return []byte{1<<5 | byte(offset>>8&0x7), byte(offset)}
+}
+
+// buildTestMmdb builds an ipv4 database (record size 24) in which 1.2.3.0/24 is in the country "IT".
+// the record of the last bit of the network points to dataOffset of the data section
+func buildTestMmdb(nodeCount uint32, dataOffset int) []byte {
+	const treeDepth = 24
+	prefix := []byte{1, 2, 3}
+
+	var file []byte
+	for node := range treeDepth {
+		bit := prefix[node/8] >> (7 - node%8) & 1
+		records := [2]uint32{treeDepth, treeDepth} // not found
+		records[bit] = uint32(node + 1)
+		if node == treeDepth-1 {
+			records[bit] = treeDepth + 16 + uint32(dataOffset)
+		}
+		for _, record := range records {
+			file = append(file, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+	file = append(file, make([]byte, 16)...)
+	file = append(file, mmdbMap(mmdbString("country"), mmdbMap(mmdbString("iso_code"), mmdbString("IT")))...)
+	file = append(file, mmdbMetadataMarker...)
+	return append(file, mmdbMap(
+		mmdbString("node_count"), mmdbUint32(nodeCount),
+		mmdbString("record_size"), mmdbUint32(24),
+		mmdbString("ip_version"), mmdbUint32(4),
+	)...)
+}
+
+func TestMmdbLookup(t *testing.T) {
+	db, err := parseMmdb(buildTestMmdb(24, 0))
+	if err != nil {
+		t.Fatalf("parseMmdb: %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"1.2.3.4", "IT"},
+		{"1.2.3.255", "IT"},
+		{"1.2.4.1", ""},
+		{"192.168.1.10", ""},
+		// ipv6 addresses are not in an ipv4 database
+		{"2001:db8::1", ""},
+	}
+	for _, test := range tests {
+		record, err := db.lookup(net.ParseIP(test.ip))
+		if err != nil {
+			t.Errorf("lookup(%s): %v", test.ip, err)
+			continue
+		}
+		country, _ := record["country"].(map[string]interface{})
+		if isoCode, _ := country["iso_code"].(string); isoCode != test.want {
+			t.Errorf("lookup(%s) = %v, want country %q", test.ip, record, test.want)
+		}
+	}
+}
+
+func TestParseMmdbInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"no metadata":        []byte("not a database"),
+		"huge node count":    buildTestMmdb(0xFFFFFFFF, 0),
+		"tree after the end": buildTestMmdb(1000, 0),
+	}
+	for name, data := range tests {
+		if _, err := parseMmdb(data); err == nil {
+			t.Errorf("parseMmdb(%s): expected an error", name)
+		}
+	}
+
+	// the search tree points after the data section
+	db, err := parseMmdb(buildTestMmdb(24, 5000))
+	if err != nil {
+		t.Fatalf("parseMmdb: %v", err)
+	}
+	if _, err := db.lookup(net.ParseIP("1.2.3.4")); err == nil {
+		t.Error("lookup with a data pointer out of the file: expected an error")
+	}
+}
+
+func TestDecodeMmdbValueInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		// a map whose value points to the map itself
+		"pointer cycle":        mmdbMap(mmdbString("a"), mmdbPointer(0)),
+		"pointer to pointer":   append(mmdbPointer(2), mmdbPointer(0)...),
+		"pointer out of range": mmdbPointer(1000),
+		"truncated string":     mmdbString("iso_code")[:4],
+		// a map of 16 million entries in 5 bytes
+		"huge map": {7<<5 | 31, 0xFF, 0xFF, 0xFF},
+	}
+	for name, section := range tests {
+		if value, _, err := decodeMmdbValue(section, 0); err == nil {
+			t.Errorf("decodeMmdbValue(%s) = %v, expected an error", name, value)
+		}
+	}
+
+	// the same value can be pointed to several times
+	section := mmdbMap(mmdbString("a"), mmdbString("x"), mmdbString("b"), mmdbPointer(3), mmdbString("c"), mmdbPointer(3))
+	value, _, err := decodeMmdbValue(section, 0)
+	if err != nil {
+		t.Fatalf("decodeMmdbValue: %v", err)
+	}
+	if m := value.(map[string]interface{}); m["a"] != "x" || m["b"] != "x" || m["c"] != "x" {
+		t.Errorf("decodeMmdbValue = %v", m)
+	}
+}
+
+func TestLoadGeoipDatabaseFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+
+	// missing and invalid databases are reported as unavailable until the file changes
+	for _, data := range []string{"", "not a database"} {
+		if data != "" {
+			if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for range 2 {
+			if _, err := loadGeoipDatabase(path); !errors.Is(err, errGeoipUnavailable) {
+				t.Errorf("loadGeoipDatabase(%q) error = %v, want errGeoipUnavailable", data, err)
+			}
+		}
+	}
+
+	if err := os.WriteFile(path, buildTestMmdb(24, 0), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	db, err := loadGeoipDatabase(path)
+	if err != nil || !strings.HasSuffix(db.path, "country.mmdb") {
+		t.Errorf("loadGeoipDatabase after fixing the file: %v", err)
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// historyRecord is a line of the history file (History.File, one json object per line)
+type historyRecord struct {
+	Type          string    `json:"type"` // "session", "wake" or "state"
+	Player        string    `json:"player,omitempty"`
+	ClientAddress string    `json:"clientAddress,omitempty"`
+	Since         time.Time `json:"since"`
+	Until         time.Time `json:"until"`
+	// session only
+	BytesToServer    int64  `json:"bytesToServer,omitempty"`
+	BytesToClient    int64  `json:"bytesToClient,omitempty"`
+	DisconnectReason string `json:"disconnectReason,omitempty"`
+	// wake only: why the server was started and stopped, how many players joined while it was running
+	Reason     string `json:"reason,omitempty"`
+	StopReason string `json:"stopReason,omitempty"`
+	Joins      int    `json:"joins,omitempty"`
+	// state only: the new state of the server (Since == Until == time of the change, Reason == why it changed)
+	State string `json:"state,omitempty"`
+}
+
+// playtimeEntry is the time played by a player
+type playtimeEntry struct {
+	Player   string    `json:"player"`
+	Sessions int       `json:"sessions"`
+	Seconds  int64     `json:"seconds"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// hourEntry is the activity in an hour of the day (local time)
+type hourEntry struct {
+	Hour        int     `json:"hour"`
+	Joins       int     `json:"joins"`
+	PlayerHours float64 `json:"playerHours"`
+}
+
+// wakeStats summarizes the wakes of the server
+type wakeStats struct {
+	Wakes             int            `json:"wakes"`
+	WakesWithPlay     int            `json:"wakesWithPlay"`
+	PlayRatio         float64        `json:"playRatio"`
+	AverageRunSeconds int64          `json:"averageRunSeconds"`
+	ByReason          map[string]int `json:"byReason"`
+}
+
+// historyQueries contains the queries accepted by queryHistory
+var historyQueries = []string{"playtime", "hours", "wakes"}
+
+// currentWake is the wake being recorded (nil while the server is offline)
+var currentWake *historyRecord
+var historyMutex = &sync.Mutex{}
+
+// recordStateHistory writes the state change and keeps track of the wakes:
+// a wake starts when the server starts and is written when it goes offline
+func recordStateHistory(newStatus string, details event) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	now := time.Now()
+	writeHistoryRecord(historyRecord{Type: "state", State: newStatus, Since: now, Until: now, Reason: details.Reason})
+
+	switch {
+	case newStatus == "starting" || (newStatus == "online" && currentWake == nil):
+		currentWake = &historyRecord{
+			Type:          "wake",
+			Player:        details.Player,
+			ClientAddress: details.ClientAddress,
+			Since:         time.Now(),
+			Reason:        details.Reason,
+		}
+	case newStatus == "offline" && currentWake != nil:
+		currentWake.Until = time.Now()
+		currentWake.StopReason = details.Reason
+		writeHistoryRecord(*currentWake)
+		currentWake = nil
+	}
+}
+
+// recordJoinHistory counts a player joining during the current wake
+func recordJoinHistory() {
+	historyMutex.Lock()
+	if currentWake != nil {
+		currentWake.Joins++
+	}
+	historyMutex.Unlock()
+}
+
+// recordSessionHistory writes a closed player session to the history file
+func recordSessionHistory(s session) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	writeHistoryRecord(historyRecord{
+		Type:             "session",
+		Player:           s.PlayerName,
+		ClientAddress:    s.ClientAddress,
+		Since:            s.Since,
+		Until:            s.Until,
+		BytesToServer:    s.BytesToServer,
+		BytesToClient:    s.BytesToClient,
+		DisconnectReason: s.DisconnectReason,
+	})
+}
+
+// writeHistoryRecord appends record to the history file (historyMutex must be locked)
+func writeHistoryRecord(record historyRecord) {
+	if !conf().History.Enabled {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	file, err := os.OpenFile(conf().History.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logMsh.Error("writeHistoryRecord: error while opening history file", "error", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		logMsh.Error("writeHistoryRecord: error while writing history file", "error", err)
+	}
+}
+
+// historySkew is how much earlier than a previous line a record can end: the sessions are written
+// a moment after they are closed, while other records may be written in between
+const historySkew = time.Hour
+
+// readHistory returns the records of the history file that ended after since (invalid lines are skipped)
+func readHistory(since time.Time) ([]historyRecord, error) {
+	file, err := os.Open(conf().History.File)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("history: %v", err)
+	}
+	defer file.Close()
+
+	offset, err := historyOffset(file, since)
+	if err != nil {
+		return nil, fmt.Errorf("history: %v", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("history: %v", err)
+	}
+
+	var records []historyRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record historyRecord
+		if json.Unmarshal(scanner.Bytes(), &record) != nil {
+			continue
+		}
+		if record.Until.Before(since) {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// historyOffset returns the offset in the history file of the first record that ended after since (minus historySkew).
+// the records are appended when they end, so the file is sorted by Until: the offset is found with a binary search
+// and the lines before it are never decoded
+func historyOffset(file *os.File, since time.Time) (int64, error) {
+	if since.IsZero() {
+		return 0, nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	since = since.Add(-historySkew)
+
+	// recordAt returns the offset and the end of the first valid record that starts at pos or later
+	// (found is false if there is none)
+	var recordAt = func(pos int64) (offset int64, until time.Time, found bool) {
+		offset = max(pos-1, 0)
+		reader := bufio.NewReader(io.NewSectionReader(file, offset, size-offset))
+		if pos > 0 {
+			// pos can be in the middle of a line: the next line starts after the newline
+			skipped, err := reader.ReadBytes('\n')
+			if err != nil {
+				return 0, until, false
+			}
+			offset += int64(len(skipped))
+		}
+		for {
+			line, err := reader.ReadBytes('\n')
+			var record historyRecord
+			if json.Unmarshal(line, &record) == nil {
+				return offset, record.Until, true
+			}
+			if err != nil {
+				return 0, until, false
+			}
+			offset += int64(len(line))
+		}
+	}
+
+	low, high := int64(0), size
+	for low < high {
+		middle := low + (high-low)/2
+		if _, until, found := recordAt(middle); !found || !until.Before(since) {
+			high = middle
+		} else {
+			low = middle + 1
+		}
+	}
+	if offset, _, found := recordAt(low); found {
+		return offset, nil
+	}
+	return size, nil
+}
+
+// compactHistory removes from the history file the records that ended more than History.RetentionDays ago
+func compactHistory() {
+	c := conf()
+	if !c.History.Enabled || c.History.RetentionDays <= 0 {
+		return
+	}
+
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	file, err := os.Open(c.History.File)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		logMsh.Error("compactHistory: error while opening history file", "error", err)
+		return
+	}
+	defer file.Close()
+
+	offset, err := historyOffset(file, time.Now().AddDate(0, 0, -c.History.RetentionDays))
+	if err != nil || offset == 0 {
+		return
+	}
+
+	// the records kept are written to a new file that replaces the history file
+	tmpPath := c.History.File + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		logMsh.Error("compactHistory: error while creating the compacted history file", "error", err)
+		return
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err == nil {
+		_, err = io.Copy(tmp, file)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, c.History.File)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		logMsh.Error("compactHistory: error while writing the compacted history file", "error", err)
+		return
+	}
+	logMsh.Info("history compacted", "removed_bytes", offset, "retention_days", c.History.RetentionDays)
+}
+
+// watchHistoryRetention compacts the history file when msh starts and then once a day
+func watchHistoryRetention() {
+	for {
+		compactHistory()
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+// queryPlaytime returns the time played by each player since since (most active first)
+func queryPlaytime(since time.Time) ([]playtimeEntry, error) {
+	records, err := readHistory(since)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]*playtimeEntry{}
+	for _, record := range records {
+		if record.Type != "session" {
+			continue
+		}
+		entry, ok := entries[record.Player]
+		if !ok {
+			entry = &playtimeEntry{Player: record.Player}
+			entries[record.Player] = entry
+		}
+		entry.Sessions++
+		entry.Seconds += int64(record.Until.Sub(record.Since).Seconds())
+		if record.Until.After(entry.LastSeen) {
+			entry.LastSeen = record.Until
+		}
+	}
+
+	list := []playtimeEntry{}
+	for _, entry := range entries {
+		list = append(list, *entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Seconds != list[j].Seconds {
+			return list[i].Seconds > list[j].Seconds
+		}
+		return list[i].Player < list[j].Player
+	})
+	return list, nil
+}
+
+// queryBusiestHours returns the joins and the hours played in each hour of the day since since
+func queryBusiestHours(since time.Time) ([]hourEntry, error) {
+	records, err := readHistory(since)
+	if err != nil {
+		return nil, err
+	}
+
+	hours := make([]hourEntry, 24)
+	for hour := range hours {
+		hours[hour].Hour = hour
+	}
+	for _, record := range records {
+		if record.Type != "session" {
+			continue
+		}
+		hours[record.Since.Local().Hour()].Joins++
+
+		// the session time is split between the hours it spans
+		for start := record.Since.Local(); start.Before(record.Until); {
+			end := time.Date(start.Year(), start.Month(), start.Day(), start.Hour()+1, 0, 0, 0, start.Location())
+			if end.After(record.Until) {
+				end = record.Until
+			}
+			hours[start.Hour()].PlayerHours += end.Sub(start).Hours()
+			start = end
+		}
+	}
+	return hours, nil
+}
+
+// queryWakes returns how many times the server was started since since and how many of them resulted in play
+func queryWakes(since time.Time) (wakeStats, error) {
+	records, err := readHistory(since)
+	if err != nil {
+		return wakeStats{}, err
+	}
+
+	stats := wakeStats{ByReason: map[string]int{}}
+	var runSeconds int64
+	for _, record := range records {
+		if record.Type != "wake" {
+			continue
+		}
+		stats.Wakes++
+		if record.Joins > 0 {
+			stats.WakesWithPlay++
+		}
+		stats.ByReason[record.Reason]++
+		runSeconds += int64(record.Until.Sub(record.Since).Seconds())
+	}
+	if stats.Wakes > 0 {
+		stats.PlayRatio = float64(stats.WakesWithPlay) / float64(stats.Wakes)
+		stats.AverageRunSeconds = runSeconds / int64(stats.Wakes)
+	}
+	return stats, nil
+}
+
+// queryHistory executes the history query named query ("playtime", "hours" or "wakes") on the last days (0: all)
+func queryHistory(query string, days int) (interface{}, error) {
+	var since time.Time
+	if days > 0 {
+		since = time.Now().AddDate(0, 0, -days)
+	}
+	switch query {
+	case "playtime":
+		return queryPlaytime(since)
+	case "hours":
+		return queryBusiestHours(since)
+	case "wakes":
+		return queryWakes(since)
+	}
+	return nil, fmt.Errorf("unknown history query %q (valid: playtime, hours, wakes)", query)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestHistory writes a history file with a session ending every hour for days days (until now),
+// with some invalid lines, and makes the configuration use it
+func writeTestHistory(t *testing.T, days, retentionDays int) (string, time.Time) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "msh-history.jsonl")
+	useTestConfiguration(t, func(c *configuration) {
+		c.History.Enabled = true
+		c.History.File = path
+		c.History.RetentionDays = retentionDays
+	})
+
+	now := time.Now().Truncate(time.Hour)
+	var lines []string
+	for hour := days * 24; hour >= 0; hour-- {
+		until := now.Add(-time.Duration(hour) * time.Hour)
+		line, _ := json.Marshal(historyRecord{Type: "session", Player: "Steve", Since: until.Add(-30 * time.Minute), Until: until})
+		lines = append(lines, string(line))
+		if hour%50 == 0 {
+			lines = append(lines, "{not json")
+		}
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, now
+}
+
+func TestReadHistorySince(t *testing.T) {
+	_, now := writeTestHistory(t, 30, 0)
+
+	for _, hoursAgo := range []int{0, 1, 5, 24, 7 * 24, 30 * 24, 40 * 24} {
+		since := now.Add(-time.Duration(hoursAgo) * time.Hour)
+		records, err := readHistory(since)
+		if err != nil {
+			t.Fatalf("readHistory: %v", err)
+		}
+		want := min(hoursAgo, 30*24) + 1
+		if len(records) != want {
+			t.Errorf("readHistory(%d hours ago): %d records, want %d", hoursAgo, len(records), want)
+			continue
+		}
+		if records[0].Until.Before(since) {
+			t.Errorf("readHistory(%d hours ago): record ending at %v", hoursAgo, records[0].Until)
+		}
+	}
+
+	if records, _ := readHistory(now.Add(time.Hour)); len(records) != 0 {
+		t.Errorf("readHistory(future): %d records, want 0", len(records))
+	}
+	if records, _ := readHistory(time.Time{}); len(records) != 30*24+1 {
+		t.Errorf("readHistory(all): %d records, want %d", len(records), 30*24+1)
+	}
+}
+
+func TestCompactHistory(t *testing.T) {
+	path, now := writeTestHistory(t, 30, 7)
+
+	compactHistory()
+
+	records, err := readHistory(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the records of the last 7 days are kept, and a few before them (historySkew)
+	cutoff := now.AddDate(0, 0, -7)
+	if len(records) < 7*24+1 || records[0].Until.Before(cutoff.Add(-historySkew)) {
+		t.Errorf("after the compaction: %d records from %v, want the records since %v", len(records), records[0].Until, cutoff)
+	}
+	if last := records[len(records)-1]; !last.Until.Equal(now) {
+		t.Errorf("last record lost in the compaction: %v", last.Until)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary file left after the compaction")
+	}
+}
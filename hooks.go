@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// hookEvents contains the events that can trigger a hook
+var hookEvents = []string{"starting", "online", "stopping", "offline", "crashed", "player_join", "player_leave", "wake_denied"}
+
+// startHooks launches the goroutine that executes the non blocking hooks when an event is published
+func startHooks() {
+	events := subscribeEventsLossless(hookEvents...)
+	go func() {
+		for e := range events {
+			for _, hook := range conf().Hooks.Commands {
+				if hook.Event == e.Type && !hook.Blocking {
+					go runHook(hook, e)
+				}
+			}
+		}
+	}()
+}
+
+// runBlockingHooks executes the blocking hooks of eventType one after the other.
+// returns an error as soon as a hook fails or times out
+func runBlockingHooks(eventType string, e event) error {
+	e.Type = eventType
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, hook := range conf().Hooks.Commands {
+		if hook.Event != eventType || !hook.Blocking {
+			continue
+		}
+		if err := runHook(hook, e); err != nil {
+			return fmt.Errorf("%s hook %q: %v", eventType, hook.Command, err)
+		}
+	}
+	return nil
+}
+
+// runHook executes the hook command with bash. the event details are passed as environment variables:
+// MSH_EVENT, MSH_TIME, MSH_PLAYER, MSH_IP, MSH_REASON, MSH_UPTIME, MSH_STATE, MSH_PLAYERS
+func runHook(hook hookCommand, e event) error {
+	timeout := hook.Timeout
+	if timeout == 0 {
+		timeout = conf().Hooks.DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/bash", "-c", hook.Command)
+	cmd.Env = append(os.Environ(),
+		"MSH_EVENT="+e.Type,
+		"MSH_TIME="+e.Time.Format(time.RFC3339),
+		"MSH_PLAYER="+e.Player,
+		"MSH_IP="+e.ClientAddress,
+		"MSH_REASON="+e.Reason,
+		"MSH_UPTIME="+strconv.Itoa(e.Uptime),
+		"MSH_STATE="+serverStatus,
+		"MSH_PLAYERS="+strconv.Itoa(players),
+	)
+	// the hook runs in its own process group so that a timeout kills also the processes it launched
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = time.Second
+
+	logProcess.Debug("runHook: running hook", "event", e.Type, "command", hook.Command)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %ds", timeout)
+	}
+	if len(output) > 0 {
+		logProcess.Debug("runHook: hook output", "event", e.Type, "command", hook.Command, "output", strings.TrimRight(string(output), "\n"))
+	}
+	if err != nil {
+		logProcess.Warn("runHook: hook failed", "event", e.Type, "command", hook.Command, "error", err)
+	}
+	return err
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"math"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tokenBucket is the rate limit state of a source ip for a kind of request
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take removes a token from the bucket, refilled with ratePerMinute tokens per minute up to burst.
+// returns false if the bucket is empty
+func (b *tokenBucket) take(ratePerMinute, burst int) bool {
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Minutes()*float64(ratePerMinute))
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// clientLimits contains the rate limits, protocol violations and ban of a source ip
+type clientLimits struct {
+	buckets     map[string]*tokenBucket // "connection", "status" or "wake" -> bucket
+	violations  []time.Time
+	bannedUntil time.Time
+	lastSeen    time.Time
+}
+
+// to keep track of the limits of each source ip
+var clientLimitsByIP = map[string]*clientLimits{}
+var limitsMutex = &sync.Mutex{}
+
+// client connections currently open (checked against Limits.MaxConnections)
+var openConnections int64
+
+// limitedConn is a client connection counted in openConnections until it is closed
+type limitedConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(&openConnections, -1) })
+	return c.Conn.Close()
+}
+
+// admitClient checks the access rules, the bans, the connection rate limit and the connection cap for a new client connection.
+// if the client is admitted it returns the connection to use, otherwise the connection is closed
+func admitClient(conn net.Conn) (net.Conn, bool) {
+	clientAddress := clientAddressOf(conn)
+
+	if !checkAnyAccess(clientAddress) {
+		recordRefused("access_rules")
+		logProxy.Debug("connection refused by the access rules", "client_ip", clientAddress)
+		conn.Close()
+		return nil, false
+	}
+	if isBanned(clientAddress) {
+		recordRefused("banned")
+		logProxy.Debug("connection refused: client is banned", "client_ip", clientAddress)
+		conn.Close()
+		return nil, false
+	}
+	if !allowRequest(clientAddress, "connection") {
+		conn.Close()
+		return nil, false
+	}
+	if maxConnections := int64(conf().Limits.MaxConnections); atomic.AddInt64(&openConnections, 1) > maxConnections && maxConnections > 0 {
+		atomic.AddInt64(&openConnections, -1)
+		recordRefused("max_connections")
+		logProxy.Debug("connection refused: too many connections", "client_ip", clientAddress, "max_connections", maxConnections)
+		conn.Close()
+		return nil, false
+	}
+
+	return &limitedConn{Conn: conn}, true
+}
+
+// allowRequest takes a token from the bucket of clientAddress for kind ("connection", "status" or "wake").
+// returns false if the client exceeded the rate limit of kind
+func allowRequest(clientAddress, kind string) bool {
+	var ratePerMinute, burst int
+	switch kind {
+	case "connection":
+		ratePerMinute, burst = conf().Limits.ConnectionsPerMinute, conf().Limits.ConnectionsBurst
+	case "status":
+		ratePerMinute, burst = conf().Limits.StatusPerMinute, conf().Limits.StatusBurst
+	case "wake":
+		ratePerMinute, burst = conf().Limits.WakesPerMinute, conf().Limits.WakesBurst
+	}
+	if ratePerMinute <= 0 {
+		return true
+	}
+
+	limitsMutex.Lock()
+	client := getClientLimits(clientAddress)
+	bucket, ok := client.buckets[kind]
+	if !ok {
+		bucket = &tokenBucket{}
+		client.buckets[kind] = bucket
+	}
+	allowed := bucket.take(ratePerMinute, max(burst, 1))
+	limitsMutex.Unlock()
+
+	if !allowed {
+		recordRefused(kind + "_rate")
+		logProxy.Debug("rate limit exceeded", "client_ip", clientAddress, "limit", kind)
+	}
+	return allowed
+}
+
+// recordProtocolViolation counts a protocol violation of clientAddress. after Limits.MaxViolations violations
+// in Limits.ViolationWindow seconds the client is banned for Limits.BanDuration seconds
+func recordProtocolViolation(clientAddress, reason string) {
+	logProtocol.Debug("protocol violation", "client_ip", clientAddress, "reason", reason)
+
+	limits := conf().Limits
+	if limits.MaxViolations <= 0 {
+		return
+	}
+
+	limitsMutex.Lock()
+	defer limitsMutex.Unlock()
+
+	client := getClientLimits(clientAddress)
+	now := time.Now()
+	windowStart := now.Add(-time.Duration(limits.ViolationWindow) * time.Second)
+	client.violations = slices.DeleteFunc(client.violations, func(t time.Time) bool { return t.Before(windowStart) })
+	client.violations = append(client.violations, now)
+
+	if len(client.violations) >= limits.MaxViolations {
+		client.bannedUntil = now.Add(time.Duration(limits.BanDuration) * time.Second)
+		client.violations = nil
+		atomic.AddInt64(&clientBansTotal, 1)
+		logProxy.Warn("client banned for protocol violations", "client_ip", clientAddress, "reason", reason, "seconds", limits.BanDuration)
+	}
+}
+
+// isBanned returns true if clientAddress is temporarily banned
+func isBanned(clientAddress string) bool {
+	limitsMutex.Lock()
+	defer limitsMutex.Unlock()
+
+	client, ok := clientLimitsByIP[clientAddress]
+	return ok && client.bannedUntil.After(time.Now())
+}
+
+// bannedClients returns how many source ips are currently banned
+func bannedClients() int {
+	limitsMutex.Lock()
+	defer limitsMutex.Unlock()
+
+	count := 0
+	for _, client := range clientLimitsByIP {
+		if client.bannedUntil.After(time.Now()) {
+			count++
+		}
+	}
+	return count
+}
+
+// getClientLimits returns the limits of clientAddress, creating them if needed (limitsMutex must be locked)
+func getClientLimits(clientAddress string) *clientLimits {
+	client, ok := clientLimitsByIP[clientAddress]
+	if !ok {
+		client = &clientLimits{buckets: map[string]*tokenBucket{}}
+		clientLimitsByIP[clientAddress] = client
+	}
+	client.lastSeen = time.Now()
+	return client
+}
+
+// cleanClientLimits forgets every minute the source ips that are not banned and were not seen for 10 minutes
+func cleanClientLimits() {
+	for range time.Tick(time.Minute) {
+		limitsMutex.Lock()
+		for clientAddress, client := range clientLimitsByIP {
+			if time.Since(client.lastSeen) > 10*time.Minute && client.bannedUntil.Before(time.Now()) {
+				delete(clientLimitsByIP, clientAddress)
+			}
+		}
+		limitsMutex.Unlock()
+	}
+}
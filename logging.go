@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// logSubsystems contains the parts of msh that have their own log level:
+// msh (everything else), proxy (client connections), process (minecraft server process), protocol (minecraft protocol)
+var logSubsystems = []string{"msh", "proxy", "process", "protocol"}
+
+// current log level of each subsystem (changed by config reload, control socket and api)
+var logLevels = map[string]*slog.LevelVar{}
+
+// loggers of the subsystems (replaced by initLogging with the configured format)
+var (
+	logMsh      = slog.Default()
+	logProxy    = slog.Default()
+	logProcess  = slog.Default()
+	logProtocol = slog.Default()
+)
+
+func init() {
+	for _, subsystem := range logSubsystems {
+		logLevels[subsystem] = new(slog.LevelVar)
+	}
+}
+
+// levelFilterHandler drops the records below the level of its subsystem
+type levelFilterHandler struct {
+	slog.Handler
+	level *slog.LevelVar
+}
+
+func (h levelFilterHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h levelFilterHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelFilterHandler{h.Handler.WithAttrs(attrs), h.level}
+}
+
+func (h levelFilterHandler) WithGroup(name string) slog.Handler {
+	return levelFilterHandler{h.Handler.WithGroup(name), h.level}
+}
+
+// initLogging creates the subsystem loggers with the format specified in Log.Format (text or json).
+// the output goes to stderr and to the log tail used by the dashboard and the control socket
+func initLogging() {
+	output := io.MultiWriter(os.Stderr, logTail)
+	// the level is checked by levelFilterHandler
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+
+	var handler slog.Handler
+	if conf().Log.Format == "json" {
+		handler = slog.NewJSONHandler(output, options)
+	} else {
+		handler = slog.NewTextHandler(output, options)
+	}
+
+	var newLogger = func(subsystem string) *slog.Logger {
+		return slog.New(levelFilterHandler{handler, logLevels[subsystem]}).With("subsystem", subsystem)
+	}
+	logMsh = newLogger("msh")
+	logProxy = newLogger("proxy")
+	logProcess = newLogger("process")
+	logProtocol = newLogger("protocol")
+
+	applyLogLevels(conf())
+
+	// the remaining log.Printf calls are logged with level info by the msh logger
+	slog.SetDefault(logMsh)
+	log.SetFlags(0)
+}
+
+// applyLogLevels sets the level of each subsystem from the configuration
+// (Advanced.Debug is the same as Log.Level = "debug")
+func applyLogLevels(c *configuration) {
+	defaultLevel := c.Log.Level
+	if c.Advanced.Debug {
+		defaultLevel = "debug"
+	}
+	levels := map[string]string{
+		"msh":      defaultLevel,
+		"proxy":    c.Log.ProxyLevel,
+		"process":  c.Log.ProcessLevel,
+		"protocol": c.Log.ProtocolLevel,
+	}
+	for subsystem, level := range levels {
+		if level == "" {
+			level = defaultLevel
+		}
+		// the levels were already checked by validate()
+		parsedLevel, _ := parseLogLevel(level)
+		logLevels[subsystem].Set(parsedLevel)
+	}
+}
+
+// setLogLevel changes the level of subsystem at runtime (until the next config reload)
+func setLogLevel(subsystem, level string) error {
+	levelVar, ok := logLevels[subsystem]
+	if !ok {
+		return fmt.Errorf("unknown subsystem %q (valid: %s)", subsystem, strings.Join(logSubsystems, ", "))
+	}
+	parsedLevel, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	levelVar.Set(parsedLevel)
+	logMsh.Info("log level changed", "log_subsystem", subsystem, "level", parsedLevel.String())
+	return nil
+}
+
+// getLogLevels returns the current level of each subsystem
+func getLogLevels() map[string]string {
+	levels := map[string]string{}
+	for subsystem, levelVar := range logLevels {
+		levels[subsystem] = strings.ToLower(levelVar.Level().String())
+	}
+	return levels
+}
+
+// parseLogLevel converts debug, info, warn or error to a slog level
+func parseLogLevel(level string) (slog.Level, error) {
+	var parsedLevel slog.Level
+	if !slices.Contains([]string{"debug", "info", "warn", "error"}, strings.ToLower(level)) {
+		return parsedLevel, fmt.Errorf("invalid log level %q (valid: debug, info, warn, error)", level)
+	}
+	err := parsedLevel.UnmarshalText([]byte(level))
+	return parsedLevel, err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// to keep track of the maintenance mode (on while Maintenance.FlagFile exists).
+// during maintenance only the admins can wake and join the server and msh doesn't start or stop it on its own
+var maintenanceMode atomic.Bool
+
+// isMaintenance returns true if the maintenance mode is on
+func isMaintenance() bool {
+	return maintenanceMode.Load()
+}
+
+// setMaintenance turns the maintenance mode on or off by creating or removing the flag file
+// (reason describes who requested it)
+func setMaintenance(enabled bool, reason string) error {
+	flagFile := conf().Maintenance.FlagFile
+	if enabled {
+		content := fmt.Sprintf("maintenance mode turned on by %s at %s\n", reason, time.Now().Format(time.RFC3339))
+		if err := os.WriteFile(flagFile, []byte(content), 0644); err != nil {
+			return fmt.Errorf("maintenance: %v", err)
+		}
+	} else if err := os.Remove(flagFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("maintenance: %v", err)
+	}
+	updateMaintenance(reason)
+	return nil
+}
+
+// updateMaintenance checks the flag file and applies the changes of the maintenance mode
+func updateMaintenance(reason string) {
+	_, err := os.Stat(conf().Maintenance.FlagFile)
+	enabled := err == nil
+	if maintenanceMode.Swap(enabled) == enabled {
+		return
+	}
+
+	if enabled {
+		logMsh.Warn("MAINTENANCE MODE ON", "reason", reason, "state", serverStatus)
+		return
+	}
+
+	logMsh.Info("MAINTENANCE MODE OFF", "reason", reason, "state", serverStatus)
+	// the server could have been left running without players: check it again after {TimeBeforeStoppingEmptyServer}
+	if serverStatus == "online" {
+		mutex.Lock()
+		stopInstances++
+		mutex.Unlock()
+		time.AfterFunc(time.Duration(conf().Basic.TimeBeforeStoppingEmptyServer)*time.Second, func() { stopEmptyMinecraftServer(false) })
+	}
+}
+
+// watchMaintenance checks the flag file every 2 seconds (it can be created or removed by hand)
+func watchMaintenance() {
+	updateMaintenance("flag file")
+	for range time.Tick(2 * time.Second) {
+		updateMaintenance("flag file")
+	}
+}
+
+// isMaintenanceAdmin returns true if playerName can wake and join the server during maintenance
+func isMaintenanceAdmin(playerName string) bool {
+	if matchPlayerList(conf().Maintenance.Admins, playerName, offlineUUID(playerName)) {
+		return true
+	}
+	return conf().Maintenance.UseOps && isServerOp(playerName)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// possible values of serverStatus
+var serverStates = []string{"offline", "starting", "online"}
+
+// upper bounds (seconds) of the startup duration histogram buckets
+var startupDurationBuckets = []float64{10, 20, 30, 45, 60, 90, 120, 180, 300, 600}
+
+// counters exposed on /metrics
+var (
+	bytesToServerTotal      int64
+	bytesToClientsTotal     int64
+	hibernationPingsTotal   int64
+	clientBansTotal         int64
+	metricsMutex            = &sync.Mutex{}
+	stateTransitionsTotal   = map[string]int64{} // "from to" -> count
+	wakeAttemptsTotal       = map[string]int64{} // outcome -> count
+	notificationsTotal      = map[string]int64{} // "sink outcome" -> count
+	refusedRequestsTotal    = map[string]int64{} // reason -> count
+	stateSecondsTotal       = map[string]float64{}
+	lastStateChange         = time.Now()
+	startupDurationCounts   = make([]int64, len(startupDurationBuckets))
+	startupDurationSum      float64
+	startupDurationObserved int64
+)
+
+// recordStateTransition updates the metrics related to serverStatus changes
+func recordStateTransition(oldStatus, newStatus string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	now := time.Now()
+	stateSecondsTotal[oldStatus] += now.Sub(lastStateChange).Seconds()
+	lastStateChange = now
+	stateTransitionsTotal[oldStatus+" "+newStatus]++
+
+	if oldStatus == "starting" && newStatus == "online" && !serverStartTime.IsZero() {
+		duration := now.Sub(serverStartTime).Seconds()
+		for i, bound := range startupDurationBuckets {
+			if duration <= bound {
+				startupDurationCounts[i]++
+			}
+		}
+		startupDurationSum += duration
+		startupDurationObserved++
+	}
+}
+
+// recordWakeAttempt counts a player trying to wake the server with the specified outcome
+func recordWakeAttempt(outcome string) {
+	metricsMutex.Lock()
+	wakeAttemptsTotal[outcome]++
+	metricsMutex.Unlock()
+}
+
+// recordNotification counts a notification for sink with the specified outcome (sent, failed, suppressed)
+func recordNotification(sink, outcome string) {
+	metricsMutex.Lock()
+	notificationsTotal[sink+" "+outcome]++
+	metricsMutex.Unlock()
+}
+
+// recordRefused counts a client connection or request refused by the limits for reason
+// (connection_rate, status_rate, wake_rate, max_connections, banned, access_rules)
+func recordRefused(reason string) {
+	metricsMutex.Lock()
+	refusedRequestsTotal[reason]++
+	metricsMutex.Unlock()
+}
+
+// recordProxiedBytes counts the bytes forwarded in the specified direction
+func recordProxiedBytes(dataLen int, isServerToClient bool) {
+	if isServerToClient {
+		atomic.AddInt64(&bytesToClientsTotal, int64(dataLen))
+	} else {
+		atomic.AddInt64(&bytesToServerTotal, int64(dataLen))
+	}
+}
+
+// recordHibernationPing counts a status request answered by msh while the server is offline
+func recordHibernationPing() {
+	atomic.AddInt64(&hibernationPingsTotal, 1)
+}
+
+// apiMetrics answers with the metrics in prometheus text format
+func apiMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w)
+}
+
+// writeMetrics writes all the metrics in prometheus text format
+func writeMetrics(w io.Writer) {
+	var writeHeader = func(name, metricType, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+	}
+
+	currentStatus := serverStatus
+
+	writeHeader("msh_server_state", "gauge", "Current state of the minecraft server (1 for the current state).")
+	for _, state := range serverStates {
+		value := 0
+		if state == currentStatus {
+			value = 1
+		}
+		fmt.Fprintf(w, "msh_server_state{state=%q} %d\n", state, value)
+	}
+
+	writeHeader("msh_players_online", "gauge", "Players connected to the minecraft server.")
+	fmt.Fprintf(w, "msh_players_online %d\n", len(onlinePlayerNames()))
+
+	writeHeader("msh_afk_players", "gauge", "Players connected to the minecraft server that are afk.")
+	fmt.Fprintf(w, "msh_afk_players %d\n", len(afkPlayerNames()))
+
+	writeHeader("msh_active_connections", "gauge", "Client connections currently proxied to the minecraft server.")
+	fmt.Fprintf(w, "msh_active_connections %d\n", len(listSessions()))
+
+	writeHeader("msh_open_connections", "gauge", "Client connections currently open (proxied or answered by msh).")
+	fmt.Fprintf(w, "msh_open_connections %d\n", atomic.LoadInt64(&openConnections))
+
+	writeHeader("msh_banned_clients", "gauge", "Source IPs currently banned for protocol violations.")
+	fmt.Fprintf(w, "msh_banned_clients %d\n", bannedClients())
+
+	writeHeader("msh_client_bans_total", "counter", "Source IPs banned for protocol violations.")
+	fmt.Fprintf(w, "msh_client_bans_total %d\n", atomic.LoadInt64(&clientBansTotal))
+
+	writeHeader("msh_budget_used_hours", "gauge", "Hours the minecraft server ran in the current period of each runtime budget.")
+	budgets := getBudgetUsage()
+	for _, budget := range budgets {
+		fmt.Fprintf(w, "msh_budget_used_hours{period=%q} %.3f\n", budget.Period, budget.UsedHours)
+	}
+	writeHeader("msh_budget_limit_hours", "gauge", "Hours the minecraft server can run in each runtime budget period.")
+	for _, budget := range budgets {
+		fmt.Fprintf(w, "msh_budget_limit_hours{period=%q} %.3f\n", budget.Period, budget.LimitHours)
+	}
+
+	writeHeader("msh_proxied_bytes_total", "counter", "Bytes proxied between clients and the minecraft server.")
+	fmt.Fprintf(w, "msh_proxied_bytes_total{direction=\"to_server\"} %d\n", atomic.LoadInt64(&bytesToServerTotal))
+	fmt.Fprintf(w, "msh_proxied_bytes_total{direction=\"to_clients\"} %d\n", atomic.LoadInt64(&bytesToClientsTotal))
+
+	writeHeader("msh_hibernation_status_pings_total", "counter", "Status requests answered by msh while the minecraft server was hibernating.")
+	fmt.Fprintf(w, "msh_hibernation_status_pings_total %d\n", atomic.LoadInt64(&hibernationPingsTotal))
+
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	writeHeader("msh_server_state_transitions_total", "counter", "Changes of the minecraft server state.")
+	for _, key := range sortedKeys(stateTransitionsTotal) {
+		states := strings.Split(key, " ")
+		fmt.Fprintf(w, "msh_server_state_transitions_total{from=%q,to=%q} %d\n", states[0], states[1], stateTransitionsTotal[key])
+	}
+
+	writeHeader("msh_server_state_seconds_total", "counter", "Seconds spent by the minecraft server in each state.")
+	for _, state := range serverStates {
+		seconds := stateSecondsTotal[state]
+		if state == currentStatus {
+			seconds += time.Since(lastStateChange).Seconds()
+		}
+		fmt.Fprintf(w, "msh_server_state_seconds_total{state=%q} %.3f\n", state, seconds)
+	}
+
+	writeHeader("msh_wake_attempts_total", "counter", "Players trying to join while the minecraft server was not online, by outcome.")
+	for _, outcome := range sortedKeys(wakeAttemptsTotal) {
+		fmt.Fprintf(w, "msh_wake_attempts_total{outcome=%q} %d\n", outcome, wakeAttemptsTotal[outcome])
+	}
+
+	writeHeader("msh_refused_requests_total", "counter", "Client connections and requests refused by the limits, by reason.")
+	for _, reason := range sortedKeys(refusedRequestsTotal) {
+		fmt.Fprintf(w, "msh_refused_requests_total{reason=%q} %d\n", reason, refusedRequestsTotal[reason])
+	}
+
+	writeHeader("msh_notifications_total", "counter", "Notifications by sink and outcome.")
+	for _, key := range sortedKeys(notificationsTotal) {
+		sink, outcome, _ := strings.Cut(key, " ")
+		fmt.Fprintf(w, "msh_notifications_total{sink=%q,outcome=%q} %d\n", sink, outcome, notificationsTotal[key])
+	}
+
+	writeHeader("msh_events_dropped_total", "counter", "Events lost by the event stream subscribers that were not keeping up (dashboard, logs -f, console).")
+	fmt.Fprintf(w, "msh_events_dropped_total %d\n", eventsDropped.Load())
+
+	writeHeader("msh_server_startup_duration_seconds", "histogram", "Time from the start command to the minecraft server being online.")
+	for i, bound := range startupDurationBuckets {
+		fmt.Fprintf(w, "msh_server_startup_duration_seconds_bucket{le=\"%g\"} %d\n", bound, startupDurationCounts[i])
+	}
+	fmt.Fprintf(w, "msh_server_startup_duration_seconds_bucket{le=\"+Inf\"} %d\n", startupDurationObserved)
+	fmt.Fprintf(w, "msh_server_startup_duration_seconds_sum %.3f\n", startupDurationSum)
+	fmt.Fprintf(w, "msh_server_startup_duration_seconds_count %d\n", startupDurationObserved)
+}
+
+// sortedKeys returns the keys of m in alphabetical order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
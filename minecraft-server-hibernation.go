@@ -2,11 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
-	"flag"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"math"
 	"net"
 	"os"
@@ -15,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -28,27 +31,13 @@ var info []string = []string{
 	"Support the og author at: buymeacoffee.com/gekigek99",
 }
 
-//---------------------------modify---------------------------//
+//---------------------------config---------------------------//
 
-var startminecraftserver string // To modify this, have a look at the default values (third argument) of the flags in main() or pass the corresponding command line arguments.
+// all the settings are loaded from the config file, environment variables and flags (see config.go)
 
-const stopminecraftserver = "screen -S minecraftSERVER -X stuff 'stop\\n'"
-
-const minecraftServerStartupTime = 20
-const timeBeforeStoppingEmptyServer = 60
-
-//--------------------------advanced--------------------------//
-
-const listenHost = "0.0.0.0"
-const listenPort = "25555"
-
-const targetHost = "127.0.0.1"
-const targetPort = "25565"
-
-var debug bool = false
-
-var serverVersion string = "WIP"
-var serverProtocol string = "751"
+// server version and protocol shown to clients (initialized from config and updated when the server answers a status request)
+var serverVersion string
+var serverProtocol string
 
 //------------------------don't modify------------------------//
 
@@ -65,23 +54,86 @@ var dataCountBytesToClients, dataCountBytesToServer float64 = 0, 0
 var stopInstances int = 0
 
 // to keep track of how many seconds are still needed to reach serverStatus == "online"
-var timeLeftUntilUp int = minecraftServerStartupTime
+var timeLeftUntilUp int
 var mutex = &sync.Mutex{}
 
+// to allow only one startMinecraftServer() at a time
+var startMutex = &sync.Mutex{}
+
+// returned by startMinecraftServer() when a blocking "starting" hook prevented the start
+var errStartBlocked = errors.New("server start blocked")
+
+// returned by stopMinecraftServer() when a blocking "stopping" hook prevented the stop or when the stop was not
+// needed anymore after the hooks (a player joined, the server was already stopped)
+var errStopBlocked = errors.New("server stop blocked")
+var errStopCancelled = errors.New("server stop cancelled")
+
+// to keep track of when msh and the minecraft server were started (serverStartTime is zero when serverStatus == "offline")
+var mshStartTime time.Time = time.Now()
+var serverStartTime time.Time
+
 //--------------------------PROGRAM---------------------------//
 
-func startMinecraftServer() {
-	serverStatus = "starting"
-	cmd := exec.Command("/bin/bash", "-c", startminecraftserver)
-	logger("Running command: " + fmt.Sprintln(cmd))
+// setServerStatus changes serverStatus and keeps track of the transition.
+// details contains the info added to the published event (player, reason, ...)
+func setServerStatus(newStatus string, details event) {
+	oldStatus := serverStatus
+	details.Uptime = serverUptime()
+	serverStatus = newStatus
+	if oldStatus != newStatus {
+		recordStateTransition(oldStatus, newStatus)
+		recordStateHistory(newStatus, details)
+		details.Type = newStatus
+		publishEvent(details)
+	}
+}
+
+// serverUptime returns the seconds since the minecraft server was started (0 if offline)
+func serverUptime() int {
+	if startTime := serverStartTime; !startTime.IsZero() {
+		return int(time.Since(startTime).Seconds())
+	}
+	return 0
+}
+
+// startMinecraftServer issues the start server command if serverStatus == "offline".
+// trigger contains who/what requested the start (player, client address, reason)
+func startMinecraftServer(trigger event) error {
+	// only one start at a time (pre-start hooks can take some time)
+	startMutex.Lock()
+	defer startMutex.Unlock()
+
+	if serverStatus != "offline" {
+		return fmt.Errorf("server is %s", serverStatus)
+	}
+
+	// the server can't run after a runtime budget is exhausted
+	if reset := budgetExhaustedUntil(time.Now()); !reset.IsZero() {
+		return fmt.Errorf("%w until %s", errBudgetExhausted, reset.Format(time.RFC3339))
+	}
+
+	// blocking "starting" hooks can prevent the start
+	if err := runBlockingHooks("starting", trigger); err != nil {
+		logProcess.Warn("MINECRAFT SERVER START BLOCKED", "error", err, "player", trigger.Player, "client_ip", trigger.ClientAddress)
+		publishEvent(event{Type: "wake_denied", Player: trigger.Player, ClientAddress: trigger.ClientAddress, Reason: "start blocked by hook"})
+		return fmt.Errorf("%w: %v", errStartBlocked, err)
+	}
+
+	mutex.Lock()
+	serverStartTime = time.Now()
+	setServerStatus("starting", trigger)
+	mutex.Unlock()
+
+	cmd := exec.Command("/bin/bash", "-c", conf().startCommand())
+	logProcess.Debug("running start command", "command", cmd.String())
 	err := cmd.Run()
 	if err != nil {
-		log.Printf("error starting minecraft server: %v\n", err)
+		logProcess.Error("error starting minecraft server", "error", err)
 	} else {
-		logger("Server command returned: " + fmt.Sprintln(err))
+		logProcess.Debug("start command returned")
 	}
 
-	log.Print("*** MINECRAFT SERVER IS STARTING!")
+	logProcess.Info("MINECRAFT SERVER IS STARTING!", "state", "starting", "reason", trigger.Reason, "player", trigger.Player, "client_ip", trigger.ClientAddress)
 
 	// initialization of players
 	players = 0
@@ -90,17 +142,21 @@ func startMinecraftServer() {
 	//
 	// increases stopInstances by one. after {TimeBeforeStoppingEmptyServer} executes stopEmptyMinecraftServer(false)
 	var setServerStatusOnline = func() {
-		serverStatus = "online"
-		log.Print("*** MINECRAFT SERVER IS UP!")
+		setServerStatus("online", event{})
+		logProcess.Info("MINECRAFT SERVER IS UP!", "state", "online")
 
 		mutex.Lock()
 		stopInstances++
 		mutex.Unlock()
-		time.AfterFunc(time.Duration(timeBeforeStoppingEmptyServer)*time.Second, func() { stopEmptyMinecraftServer(false) })
+		time.AfterFunc(time.Duration(conf().Basic.TimeBeforeStoppingEmptyServer)*time.Second, func() { stopEmptyMinecraftServer(false) })
 	}
 	// updates timeLeftUntilUp each second. if timeLeftUntilUp == 0 it executes setServerStatusOnline()
+	// (stops if the server was stopped in the meantime)
 	var updateTimeleft func()
 	updateTimeleft = func() {
+		if serverStatus != "starting" {
+			return
+		}
 		if timeLeftUntilUp > 0 {
 			timeLeftUntilUp--
 			time.AfterFunc(1*time.Second, func() { updateTimeleft() })
@@ -110,47 +166,332 @@ func startMinecraftServer() {
 	}
 
 	time.AfterFunc(1*time.Second, func() { updateTimeleft() })
+
+	return nil
 }
 
 func stopEmptyMinecraftServer(forceExec bool) {
+	reason := "msh shutdown"
+
 	if forceExec && serverStatus != "offline" {
 		// skip some checks to issue the stop server command forcefully
 	} else {
 		// check that there is only one "stop server command" instance running and players <= 0 and serverStatus != "offline".
 		// on the contrary the server won't be stopped
 		mutex.Lock()
-		defer mutex.Unlock()
 
 		stopInstances--
-		if stopInstances > 0 || players > 0 || serverStatus == "offline" {
+		// during maintenance, "always_on" schedule windows and prewarm holds the server is never stopped automatically
+		if stopInstances > 0 || players > 0 || serverStatus == "offline" || isMaintenance() || scheduleMode(time.Now()) == "always_on" || isPrewarmHolding() {
+			idleWarningSent = false
+			mutex.Unlock()
+			return
+		}
+		reason = "idle"
+
+		// the players that the count could have missed are warned and the server is checked again before stopping it
+		// (the warning is sent after the mutex is released: the server command can take a while)
+		if warning := time.Duration(conf().Countdown.IdleWarning) * time.Second; warning > 0 && !idleWarningSent {
+			idleWarningSent = true
+			stopInstances++
+			mutex.Unlock()
+			broadcastStopWarning(warning, reason)
+			time.AfterFunc(warning, func() { stopEmptyMinecraftServer(false) })
 			return
 		}
+		idleWarningSent = false
+		mutex.Unlock()
+	}
+
+	// the hooks and the stop command are executed without holding the mutex (a player can join in the meantime:
+	// the server is stopped only if it is still empty)
+	var stillEmpty func() bool
+	if !forceExec {
+		stillEmpty = func() bool { return players == 0 }
+	}
+	if err := stopMinecraftServer(forceExec, reason, stillEmpty); errors.Is(err, errStopBlocked) {
+		// a blocking "stopping" hook prevented the stop: check again after {TimeBeforeStoppingEmptyServer}
+		mutex.Lock()
+		stopInstances++
+		mutex.Unlock()
+		time.AfterFunc(time.Duration(conf().Basic.TimeBeforeStoppingEmptyServer)*time.Second, func() { stopEmptyMinecraftServer(false) })
+	}
+}
+
+// requestStopMinecraftServer stops the minecraft server on user request (reason describes who requested it).
+// if forceExec is false the server is stopped only if there are no players online
+func requestStopMinecraftServer(forceExec bool, reason string) error {
+	if forceExec {
+		if serverStatus == "offline" {
+			return fmt.Errorf("server is offline")
+		}
+		if countdownRunning.Load() {
+			return fmt.Errorf("a stop countdown is already running")
+		}
+		// the players online are warned before the server is stopped (a forced stop can't be prevented by the hooks)
+		go stopWithCountdown(time.Now().Add(countdownDuration()), reason, true, nil)
+		return nil
+	}
+
+	mutex.Lock()
+	offline, playersOnline := serverStatus == "offline", players
+	mutex.Unlock()
+
+	if offline {
+		return fmt.Errorf("server is offline")
+	}
+	if playersOnline > 0 {
+		return fmt.Errorf("%d players online, use force to stop the server anyway", playersOnline)
+	}
+	return stopMinecraftServer(false, reason, func() bool { return players == 0 })
+}
+
+// sendServerCommand sends a console command (example: "say hello") to the minecraft server
+// using rcon (if Console.RconAddress is set) or Basic.SendCommandToServer.
+// returns the server answer (only available with rcon)
+func sendServerCommand(command string) (string, error) {
+	if serverStatus == "offline" {
+		return "", fmt.Errorf("server is offline")
+	}
+
+	if conf().Console.RconAddress != "" {
+		logProcess.Debug("sending command with rcon", "command", command)
+		return sendRconCommand(conf().Console.RconAddress, conf().Console.RconPassword, command)
+	}
+
+	cmd := exec.Command("/bin/bash", "-c", conf().Basic.SendCommandToServer)
+	cmd.Env = append(os.Environ(), "MSH_COMMAND="+command)
+	logProcess.Debug("running send command", "command", cmd.String(), "MSH_COMMAND", command)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("error sending command to minecraft server: %v %s", err, strings.TrimSpace(string(output)))
+	}
+	return "", nil
+}
+
+// stopMinecraftServer issues the stop server command without checking players.
+// after the blocking "stopping" hooks stillNeeded (nil: always needed) is checked with the mutex locked.
+// returns errStopBlocked if a blocking "stopping" hook prevented the stop (forced stops can't be prevented)
+// and errStopCancelled if the stop was not needed anymore. the mutex must not be locked by the caller
+func stopMinecraftServer(forceExec bool, reason string, stillNeeded func() bool) error {
+	details := event{Reason: reason, Uptime: serverUptime()}
+	if err := runBlockingHooks("stopping", details); err != nil {
+		if !forceExec {
+			logProcess.Warn("MINECRAFT SERVER STOP BLOCKED", "error", err, "reason", reason)
+			return fmt.Errorf("%w by hook: %v", errStopBlocked, err)
+		}
+		logProcess.Warn("stopMinecraftServer: hook failed (ignored, the stop is forced)", "error", err)
 	}
 
-	serverStatus = "offline"
-	cmd := exec.Command("/bin/bash", "-c", stopminecraftserver)
-	logger("Running command: " + fmt.Sprintln(cmd))
+	// the hooks can take a while: check again that the stop is still needed
+	var stillNeededLocked = func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return serverStatus != "offline" && (stillNeeded == nil || stillNeeded())
+	}
+	if !stillNeededLocked() {
+		logProcess.Info("minecraft server stop cancelled", "reason", reason, "state", serverStatus, "players", players)
+		return errStopCancelled
+	}
+
+	saveWorld()
+
+	details.Type = "stopping"
+	publishEvent(details)
+
+	mutex.Lock()
+	if serverStatus == "offline" {
+		mutex.Unlock()
+		return errStopCancelled
+	}
+	setServerStatus("offline", event{Reason: reason})
+	serverStartTime = time.Time{}
+	mutex.Unlock()
+
+	cmd := exec.Command("/bin/bash", "-c", conf().Basic.StopMinecraftServer)
+	logProcess.Debug("running stop command", "command", cmd.String())
 	err := cmd.Run()
 	if err != nil {
-		log.Printf("error stopping minecraft server: %v\n", err)
+		logProcess.Error("error stopping minecraft server", "error", err)
 	} else {
-		logger("MC server successfully shut down." + fmt.Sprintln(err))
+		logProcess.Debug("stop command returned")
 	}
 	if forceExec {
-		log.Print("*** MINECRAFT SERVER IS FORCEFULLY SHUTTING DOWN!")
+		logProcess.Info("MINECRAFT SERVER IS FORCEFULLY SHUTTING DOWN!", "state", "offline", "reason", reason)
 	} else {
-		log.Print("*** MINECRAFT SERVER IS SHUTTING DOWN!")
+		logProcess.Info("MINECRAFT SERVER IS SHUTTING DOWN!", "state", "offline", "reason", reason)
 	}
 
 	// reset timeLeftUntilUp to initial value
-	timeLeftUntilUp = minecraftServerStartupTime
+	timeLeftUntilUp = conf().Basic.MinecraftServerStartupTime
+
+	return nil
+}
+
+// adoptRunningMinecraftServer checks if a minecraft server is already running (port reachable or world lock held)
+// and in that case sets serverStatus accordingly and starts the idle timer
+func adoptRunningMinecraftServer() {
+	portOpen := isTargetPortOpen()
+	lockHeld := isSessionLockHeld()
+	logProcess.Debug("adoptRunningMinecraftServer: checked running server", "port_open", portOpen, "lock_held", lockHeld)
+
+	if portOpen {
+		// the server is already accepting connections
+		serverStartTime = time.Now()
+		setServerStatus("online", event{Reason: "adopted"})
+		timeLeftUntilUp = 0
+		players = 0
+		logProcess.Info("MINECRAFT SERVER IS ALREADY UP! (adopted)", "state", "online")
+
+		mutex.Lock()
+		stopInstances++
+		mutex.Unlock()
+		time.AfterFunc(time.Duration(conf().Basic.TimeBeforeStoppingEmptyServer)*time.Second, func() { stopEmptyMinecraftServer(false) })
+
+	} else if lockHeld {
+		// the server process holds the world lock but is not accepting connections yet: it is still starting up
+		serverStartTime = time.Now()
+		setServerStatus("starting", event{Reason: "adopted"})
+		players = 0
+		logProcess.Info("MINECRAFT SERVER IS ALREADY STARTING! (adopted)", "state", "starting")
+
+		// a server still loading after twice the startup time is only reported: while it holds the world lock
+		// it is running and a second instance must not be started
+		slowAfter := time.Now().Add(time.Duration(2*conf().Basic.MinecraftServerStartupTime) * time.Second)
+		slowWarned := false
+
+		// wait for the target port to open, then set serverStatus = "online" and start the idle timer.
+		// if the process releases the world lock before that, serverStatus goes back to "offline"
+		var waitOnline func()
+		waitOnline = func() {
+			if serverStatus != "starting" {
+				return
+			}
+			if timeLeftUntilUp > 0 {
+				timeLeftUntilUp--
+			}
+			if !isTargetPortOpen() {
+				if isSessionLockHeld() {
+					if !slowWarned && time.Now().After(slowAfter) {
+						slowWarned = true
+						logProcess.Warn("adopted minecraft server is taking longer than expected to start", "state", "starting", "startup_time", conf().Basic.MinecraftServerStartupTime)
+					}
+					time.AfterFunc(1*time.Second, func() { waitOnline() })
+					return
+				}
+				logProcess.Warn("ADOPTED MINECRAFT SERVER DID NOT START! (world lock released)", "state", "offline")
+				mutex.Lock()
+				if serverStatus == "starting" {
+					setServerStatus("offline", event{Reason: "adopted server did not start"})
+					serverStartTime = time.Time{}
+					timeLeftUntilUp = conf().Basic.MinecraftServerStartupTime
+				}
+				mutex.Unlock()
+				return
+			}
+			setServerStatus("online", event{})
+			timeLeftUntilUp = 0
+			logProcess.Info("MINECRAFT SERVER IS UP!", "state", "online")
+
+			mutex.Lock()
+			stopInstances++
+			mutex.Unlock()
+			time.AfterFunc(time.Duration(conf().Basic.TimeBeforeStoppingEmptyServer)*time.Second, func() { stopEmptyMinecraftServer(false) })
+		}
+		time.AfterFunc(1*time.Second, func() { waitOnline() })
+	}
+}
+
+// watchMinecraftServer checks every 10 seconds that the online minecraft server is still reachable.
+// if it isn't for 3 checks in a row (after having been reachable) the server is considered crashed
+func watchMinecraftServer() {
+	failures := 0
+	// the server is checked only once it was seen reachable (it could still be loading after the startup time)
+	seenUp := false
+
+	for range time.Tick(10 * time.Second) {
+		if serverStatus != "online" {
+			failures, seenUp = 0, false
+			continue
+		}
+		if isTargetPortOpen() {
+			failures, seenUp = 0, true
+			continue
+		}
+		if !seenUp {
+			continue
+		}
+		failures++
+		if failures < 3 {
+			continue
+		}
+
+		logProcess.Error("MINECRAFT SERVER CRASHED!", "state", "offline", "uptime", serverUptime())
+		mutex.Lock()
+		if serverStatus == "online" {
+			publishEvent(event{Type: "crashed", Reason: "server unreachable", Uptime: serverUptime()})
+			setServerStatus("offline", event{Reason: "crashed"})
+			serverStartTime = time.Time{}
+			timeLeftUntilUp = conf().Basic.MinecraftServerStartupTime
+		}
+		mutex.Unlock()
+		failures, seenUp = 0, false
+	}
+}
+
+// isTargetPortOpen returns true if a tcp connection to {TargetHost}:{TargetPort} can be established
+func isTargetPortOpen() bool {
+	conn, err := net.DialTimeout("tcp", conf().Advanced.TargetHost+":"+conf().Advanced.TargetPort, 2*time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// isSessionLockHeld returns true if another process (the minecraft server) holds a lock on the world's session.lock
+func isSessionLockHeld() bool {
+	lockPath := conf().Basic.McPath + getLevelName() + "/session.lock"
+
+	file, err := os.Open(lockPath)
+	if err != nil {
+		// no lock file --> the world was never loaded
+		return false
+	}
+	defer file.Close()
+
+	// java locks session.lock with a posix record lock: ask the kernel if a write lock could be placed
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekStart}
+	err = syscall.FcntlFlock(file.Fd(), syscall.F_GETLK, &lock)
+	if err != nil {
+		logProcess.Debug("isSessionLockHeld: error while checking lock", "path", lockPath, "error", err)
+		return false
+	}
+	return lock.Type != syscall.F_UNLCK
+}
+
+// getLevelName returns the world folder name specified in server.properties ("world" if not found)
+func getLevelName() string {
+	data, err := os.ReadFile(conf().Basic.McPath + "server.properties")
+	if err != nil {
+		return "world"
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "level-name=") {
+			if levelName := strings.TrimPrefix(line, "level-name="); levelName != "" {
+				return levelName
+			}
+		}
+	}
+	return "world"
 }
 
 // to print each second bytes/s to clients and to server
 func printDataUsage() {
 	mutex.Lock()
 	if dataCountBytesToClients != 0 || dataCountBytesToServer != 0 {
-		logger(fmt.Sprintf("data/s: %8.3f KB/s to clients | %8.3f KB/s to server", dataCountBytesToClients/1024, dataCountBytesToServer/1024))
+		logProxy.Debug("data usage", "kb_per_s_to_clients", fmt.Sprintf("%.3f", dataCountBytesToClients/1024), "kb_per_s_to_server", fmt.Sprintf("%.3f", dataCountBytesToServer/1024))
 		dataCountBytesToClients = 0
 		dataCountBytesToServer = 0
 	}
@@ -159,211 +500,413 @@ func printDataUsage() {
 }
 
 func main() {
+	// msh subcommands (status, start, stop, ...) control the running msh through the control socket
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runControlCommand(os.Args[1:]))
+	}
+
 	// prints intro to program
 	fmt.Println(strings.Join(info[1:5], "\n"))
 
-	// Flag parsing for starting MC server with Docker ENV variables
-	var minRAM string
-	var maxRAM string
-	var mcPath string
-	var mcFile string
-	var debugString string
+	// keeps the last log lines in memory for the dashboard
+	log.SetOutput(io.MultiWriter(os.Stderr, logTail))
 
-	flag.StringVar(&minRAM, "minRAM", "512M", "Specify minimum amount of RAM.")
-	flag.StringVar(&maxRAM, "maxRAM", "2G", "Specify maximum amount of RAM.")
-	flag.StringVar(&mcPath, "mcPath", "/minecraftserver/", "Specify path of Minecraft folder.")
-	flag.StringVar(&mcFile, "mcFile", "minecraft_server.jar", "Specify name of Minecraft .jar file")
-	flag.StringVar(&debugString, "debug", "false", "True turns debug logging on.")
-	flag.Parse()
-	minRAM = "-Xms" + minRAM
-	maxRAM = "-Xmx" + maxRAM
-	debug, _ = strconv.ParseBool(debugString)
+	// parses the flags and loads the config file
+	initConfiguration()
 
-	startminecraftserver = "cd " + mcPath + "; screen -dmS minecraftSERVER nice -19 java " + minRAM + " " + maxRAM + " -jar " + mcFile + " nogui"
+	// creates the loggers with the configured format and levels
+	initLogging()
 
-	fmt.Println("Container started with the following arguments: \n\tminRAM:" + minRAM + " maxRAM:" + maxRAM + " mcPath:" + mcPath + " mcFile:" + mcFile)
-	// end of flag parsing
+	fmt.Println("Container started with the following arguments: \n\tminRAM:" + conf().Basic.MinRAM + " maxRAM:" + conf().Basic.MaxRAM + " mcPath:" + conf().Basic.McPath + " mcFile:" + conf().Basic.McFile + " config:" + configPath)
 
 	// Check if MC server file exists at chosen location
-	if mcPath[len(mcPath)-1:] != "/" {
-		logger("Path without last \"/\". Adding...")
-		mcPath = mcPath + "/"
-	}
-	mcFilePath := mcPath + mcFile
-	logger("mcFilePath: " + mcFilePath)
+	mcFilePath := conf().Basic.McPath + conf().Basic.McFile
 	if _, err := os.Stat(mcFilePath); err != nil {
 		if os.IsNotExist(err) {
-			logger("MC server file not found.")
+			logProcess.Debug("MC server file not found", "path", mcFilePath)
 		}
 	} else {
-		logger("MC server file found.")
+		logProcess.Debug("MC server file found", "path", mcFilePath)
 	}
 
+	// reload the config file on SIGHUP or when it changes
+	go watchConfiguration()
+
+	// launch the hooks executed on events
+	startHooks()
+
+	// launch the notifications sent on events
+	startNotifications()
+
+	// connect to the mqtt broker (if enabled)
+	startMqtt()
+
 	// block that listen for interrupt signal and issue stopEmptyMinecraftServer(true) before exiting
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	go func() {
 		for range c {
+			// there is no time for a countdown: the players are told that the server is stopping
+			if players > 0 {
+				broadcastStopWarning(0, "msh shutdown")
+			}
 			stopEmptyMinecraftServer(true)
 			os.Exit(0)
 		}
 	}()
 
+	// record that msh was not running until now (for the uptime report)
+	recordMshStart()
+
+	// remove the old records from the history file
+	go watchHistoryRetention()
+
+	// the wake quotas survive the restarts of msh
+	loadWakeQuotas()
+
+	// if msh was restarted while the minecraft server was still running, adopt it instead of launching a second instance
+	adoptRunningMinecraftServer()
+
+	// detect when the minecraft server crashes
+	go watchMinecraftServer()
+
+	// start and stop the minecraft server as specified by the schedule windows
+	go watchSchedule()
+
+	// start the minecraft server before the hours in which players are likely to join
+	go watchPrewarm()
+
+	// stop the minecraft server when a runtime budget is exhausted
+	go watchBudget()
+
+	// warn the afk players and stop the minecraft server when all the players online are afk
+	go watchAfk()
+
+	// launch the http control api (if enabled)
+	startAPI()
+
+	// launch the control socket used by the msh subcommands
+	startControlSocket()
+
+	// launch the console: msh stdin is sent to the minecraft server and the server log is echoed
+	startConsole()
+
 	// launch printDataUsage()
 	go printDataUsage()
 
-	// open a listener on {listenHost}+":"+{listenPort}
-	listener, err := net.Listen("tcp", listenHost+":"+listenPort)
+	// forget the rate limits of the clients not seen for a while
+	go cleanClientLimits()
+
+	// turn the maintenance mode on and off when the flag file is created or removed
+	go watchMaintenance()
+
+	// open a listener on {ListenHost}+":"+{ListenPort}
+	listener, err := net.Listen("tcp", conf().Advanced.ListenHost+":"+conf().Advanced.ListenPort)
 	if err != nil {
-		log.Printf("main: Fatal error: %s", err.Error())
+		logProxy.Error("main: fatal error", "error", err)
 		time.Sleep(time.Duration(5) * time.Second)
 		os.Exit(1)
 	}
 
 	defer func() {
-		logger("Closing connection for: listener")
+		logProxy.Debug("closing listener")
 		listener.Close()
 		stopEmptyMinecraftServer(true)
 	}()
 
-	log.Println("*** listening for new clients to connect...")
+	logProxy.Info("listening for new clients to connect...", "address", listener.Addr().String())
 
 	// infinite cycle to accept clients. when a clients connects it is passed to handleClientSocket()
+	// (in a goroutine so that a slow client doesn't block the others)
 	for {
 		clientSocket, err := listener.Accept()
 		if err != nil {
-			logger("main:", err.Error())
+			logProxy.Debug("main: error while accepting client", "error", err)
+			continue
+		}
+		// banned, too frequent or too many connections are closed immediately
+		clientSocket, ok := admitClient(clientSocket)
+		if !ok {
 			continue
 		}
-		handleClientSocket(clientSocket)
+		go handleClientSocket(clientSocket)
 	}
 }
 
 // to handle a client that is connecting.
 // can handle a client that is requesting server info or trying to join.
 func handleClientSocket(clientSocket net.Conn) {
-	// to handle also ipv6 addresses
-	var lastIndex int = strings.LastIndex(clientSocket.RemoteAddr().String(), ":")
-	clientAddress := clientSocket.RemoteAddr().String()[:lastIndex]
+	clientAddress := clientAddressOf(clientSocket)
 
-	logger(fmt.Sprintf("*** from %s:%s to %s:%s", clientAddress, listenPort, targetHost, targetPort))
+	logProxy.Debug("client connected", "client_ip", clientAddress, "state", serverStatus)
 
 	// block containing the case of serverStatus == "offline" or "starting"
 	if serverStatus == "offline" || serverStatus == "starting" {
-		buffer := make([]byte, 1024)
-
-		// read first packet
-		dataLen, err := clientSocket.Read(buffer)
+		// read the first client packets to know if the client is joining and with which name
+		// (the handshake next state is 1 for the server info and ping, 2 for the login)
+		_, nextState, playerName, err := readClientIntention(clientSocket)
 		if err != nil {
-			logger("handleClientSocket: error during clientSocket.Read() 1")
+			logProtocol.Debug("handleClientSocket: error while reading client intention", "client_ip", clientAddress, "error", err)
+			if errors.Is(err, errMalformedPacket) {
+				recordProtocolViolation(clientAddress, "malformed handshake")
+			}
+			clientSocket.Close()
+			return
+		}
+
+		isInfoRequest := nextState == 1
+		isJoinRequest := nextState == 2
+
+		// too many status requests from the same ip or ip not allowed to see the status: no answer
+		if isInfoRequest && (!checkAccess(clientAddress, "status") || !allowRequest(clientAddress, "status")) {
+			clientSocket.Close()
 			return
 		}
 
-		// the client first packet is {data, 1, 1, 0} or {data, 1} --> the client is requesting server info and ping
-		if buffer[dataLen-1] == 0 || buffer[dataLen-1] == 1 {
-			if serverStatus == "offline" {
-				log.Printf("*** player unknown requested server info from %s:%s to %s:%s\n", clientAddress, listenPort, targetHost, targetPort)
+		// the client is requesting server info and ping
+		if isInfoRequest {
+			if isMaintenance() {
+				logProxy.Info("player unknown requested server info during maintenance", "client_ip", clientAddress, "state", serverStatus)
 				// answer to client with emulated server info
-				clientSocket.Write(buildMessage("info", "                   &fserver status:\n                   &b&lHIBERNATING"))
+				clientSocket.Write(buildMessage("info", conf().Messages.MaintenanceInfo))
+
+			} else if serverStatus == "offline" {
+				logProxy.Info("player unknown requested server info", "client_ip", clientAddress, "state", "offline")
+				// answer to client with emulated server info
+				message := conf().Messages.HibernationInfo
+				// show the players waiting for the wake quorum
+				if votes := pendingQuorumVotes(); isQuorumEnabled() && votes > 0 {
+					message = quorumMessage(conf().Messages.QuorumInfo, votes)
+				}
+				clientSocket.Write(buildMessage("info", message))
+				recordHibernationPing()
 
 			} else if serverStatus == "starting" {
-				log.Printf("*** player unknown requested server info from %s:%s to %s:%s during server startup\n", clientAddress, listenPort, targetHost, targetPort)
+				logProxy.Info("player unknown requested server info during server startup", "client_ip", clientAddress, "state", "starting")
 				// answer to client with emulated server info
-				clientSocket.Write(buildMessage("info", "                   &fserver status:\n                    &6&lWARMING UP"))
+				clientSocket.Write(buildMessage("info", conf().Messages.StartingInfo))
 			}
 
 			// answer to client with ping
 			answerPingReq(clientSocket)
 		}
 
-		// the client first message is [data, 211, 2] or [data, 211, 2, playerNameData] --> the client is trying to join the server
-		if bytes.Contains(buffer[:dataLen], []byte{211, 2}) {
-			var playerName string
+		// the client sent the login handshake and the login start packet with its name --> the client is trying to join the server
+		if isJoinRequest {
+			if isMaintenance() && !isMaintenanceAdmin(playerName) {
+				logProxy.Info("player tried to join during maintenance", "player", playerName, "client_ip", clientAddress, "state", serverStatus)
+				recordWakeAttempt("maintenance")
+				// answer to client with text in the loadscreen
+				clientSocket.Write(buildMessage("txt", conf().Messages.MaintenanceJoin))
+
+			} else if serverStatus == "offline" && scheduleMode(time.Now()) == "closed" {
+				logProxy.Info("player tried to join while the schedule is closed", "player", playerName, "client_ip", clientAddress, "state", "offline")
+				recordWakeAttempt("closed")
+				// answer to client with text in the loadscreen
+				clientSocket.Write(buildMessage("txt", scheduleClosedMessage()))
+
+			} else if serverStatus == "offline" && !budgetExhaustedUntil(time.Now()).IsZero() {
+				logProxy.Info("player tried to join while the runtime budget is exhausted", "player", playerName, "client_ip", clientAddress, "state", "offline")
+				recordWakeAttempt("budget")
+				// answer to client with text in the loadscreen
+				clientSocket.Write(buildMessage("txt", budgetExhaustedMessage()))
+
+			} else if serverStatus == "offline" {
+				logProxy.Info("player tried to join", "player", playerName, "client_ip", clientAddress, "state", "offline")
+
+				// the ip must be allowed to wake the server (see Access.Wake in the config)
+				if !checkAccess(clientAddress, "wake") {
+					logProxy.Info("ip is not allowed to wake the server", "player", playerName, "client_ip", clientAddress)
+					recordWakeAttempt("denied")
+					publishEvent(event{Type: "wake_denied", Player: playerName, ClientAddress: clientAddress, Reason: "ip not allowed"})
+					clientSocket.Write(buildMessage("txt", conf().Messages.WakeDenied))
+					clientSocket.Close()
+					return
+				}
 
-			// if [211, 2] are the last bytes then there is only the join request
-			// read again the client socket to get the player name packet
-			if bytes.Index(buffer[:dataLen], []byte{211, 2}) == dataLen-2 {
-				dataLen, err = clientSocket.Read(buffer)
-				if err != nil {
-					logger("handleClientSocket: error during clientSocket.Read() 2")
+				// repeated join attempts from the same ip don't wake the server
+				if !allowRequest(clientAddress, "wake") {
+					recordWakeAttempt("rate_limited")
+					clientSocket.Write(buildMessage("txt", conf().Messages.TooManyAttempts))
+					clientSocket.Close()
 					return
 				}
-				playerName = string(buffer[3:dataLen])
-			} else {
-				// the packet contains the join request and the player name in the scheme:
-				// [... 211 2 (3 bytes) (player name) 0 0 0 0 0...]
-				//  ^-----------------------dataLen-^
-				//                                    ^-zerosLen-^
-				//            ^-playerNameBuffer-----------------^
-				zerosLen := len(buffer) - dataLen
-				playerNameBuffer := bytes.SplitAfter(buffer, []byte{211, 2})[1]
-				playerName = string(playerNameBuffer[3 : len(playerNameBuffer)-zerosLen])
-			}
 
-			if serverStatus == "offline" {
+				// the player must be allowed to wake the server (see Wake in the config)
+				if err := checkWakeAllowed(playerName); err != nil {
+					if !validPlayerName.MatchString(playerName) {
+						recordProtocolViolation(clientAddress, "invalid player name")
+					}
+					logProxy.Info("player is not allowed to wake the server", "player", playerName, "client_ip", clientAddress, "reason", err.Error())
+					recordWakeAttempt("denied")
+					publishEvent(event{Type: "wake_denied", Player: playerName, ClientAddress: clientAddress, Reason: err.Error()})
+					// answer to client with text in the loadscreen
+					clientSocket.Write(buildMessage("txt", conf().Messages.WakeDenied))
+					logProxy.Debug("closing connection", "client_ip", clientAddress)
+					clientSocket.Close()
+					return
+				}
+
+				// the player must not exceed the wake quota (see WakeQuota in the config)
+				if message, err := checkWakeQuota(playerName); err != nil {
+					logProxy.Info("player reached the wake quota", "player", playerName, "client_ip", clientAddress, "reason", err.Error())
+					recordWakeAttempt("quota")
+					// answer to client with text in the loadscreen
+					clientSocket.Write(buildMessage("txt", message))
+					logProxy.Debug("closing connection", "client_ip", clientAddress)
+					clientSocket.Close()
+					return
+				}
+
+				// with the quorum mode more players must try to join before the server is started (admins don't wait)
+				if isQuorumEnabled() && !isQuorumAdmin(playerName) {
+					votes, reached := castQuorumVote(playerName, clientAddress)
+					if !reached {
+						logProxy.Info("player is waiting for the wake quorum", "player", playerName, "client_ip", clientAddress, "votes", votes, "quorum", conf().Quorum.Players)
+						recordWakeAttempt("quorum_waiting")
+						// answer to client with text in the loadscreen
+						clientSocket.Write(buildMessage("txt", quorumMessage(conf().Messages.QuorumWaiting, votes)))
+						logProxy.Debug("closing connection", "client_ip", clientAddress)
+						clientSocket.Close()
+						return
+					}
+					logProxy.Info("wake quorum reached", "player", playerName, "client_ip", clientAddress, "votes", votes)
+				}
+
 				// client is trying to join the server and serverStatus == "offline" --> issue startMinecraftServer()
-				startMinecraftServer()
-				log.Printf("*** %s tried to join from %s:%s to %s:%s\n", playerName, clientAddress, listenPort, targetHost, targetPort)
-				// answer to client with text in the loadscreen
-				clientSocket.Write(buildMessage("txt", fmt.Sprintf("Server start command issued. Please wait... Time left: %d seconds", timeLeftUntilUp)))
+				err := startMinecraftServer(event{Player: playerName, ClientAddress: clientAddress, Reason: "join"})
+				if errors.Is(err, errStartBlocked) {
+					recordWakeAttempt("blocked")
+					// answer to client with text in the loadscreen
+					clientSocket.Write(buildMessage("txt", conf().Messages.StartBlocked))
+				} else {
+					if err == nil {
+						recordWakeAttempt("started")
+						recordPlayerWake(playerName)
+					} else {
+						recordWakeAttempt("already_starting")
+					}
+					// answer to client with text in the loadscreen
+					clientSocket.Write(buildMessage("txt", strings.ReplaceAll(conf().Messages.StartCommandIssued, "{timeLeft}", strconv.Itoa(timeLeftUntilUp))))
+				}
 
 			} else if serverStatus == "starting" {
-				log.Printf("*** %s tried to join from %s:%s to %s:%s during server startup\n", playerName, clientAddress, listenPort, targetHost, targetPort)
+				logProxy.Info("player tried to join during server startup", "player", playerName, "client_ip", clientAddress, "state", "starting")
+				recordWakeAttempt("already_starting")
 				// answer to client with text in the loadscreen
-				clientSocket.Write(buildMessage("txt", fmt.Sprintf("Server is starting. Please wait... Time left: %d seconds", timeLeftUntilUp)))
+				clientSocket.Write(buildMessage("txt", strings.ReplaceAll(conf().Messages.ServerIsStarting, "{timeLeft}", strconv.Itoa(timeLeftUntilUp))))
 			}
 		}
 
 		// since the server is still not online, close the client connection
-		logger(fmt.Sprintf("closing connection for: %s", clientAddress))
+		logProxy.Debug("closing connection", "client_ip", clientAddress)
 		clientSocket.Close()
 	}
 
 	// block containing the case of serverStatus == "online"
 	if serverStatus == "online" {
+		// read the first client packets to know if the client is joining and with which name
+		data, nextState, playerName, err := readClientIntention(clientSocket)
+		if err != nil {
+			logProtocol.Debug("handleClientSocket: error while reading client intention", "client_ip", clientAddress, "error", err)
+			if errors.Is(err, errMalformedPacket) {
+				recordProtocolViolation(clientAddress, "malformed packet")
+			}
+			clientSocket.Close()
+			return
+		}
+		if nextState != 2 && (!checkAccess(clientAddress, "status") || !allowRequest(clientAddress, "status")) {
+			clientSocket.Close()
+			return
+		}
+		if nextState == 2 && !checkAccess(clientAddress, "online") {
+			logProxy.Info("ip is not allowed to join the server", "player", playerName, "client_ip", clientAddress)
+			clientSocket.Write(buildMessage("txt", conf().Messages.JoinDenied))
+			clientSocket.Close()
+			return
+		}
+
+		// during maintenance msh answers the status requests and only the admins can join
+		if isMaintenance() && nextState == 1 && data[0] != 0xFE {
+			logProxy.Info("player unknown requested server info during maintenance", "client_ip", clientAddress, "state", "online")
+			clientSocket.Write(buildMessage("info", conf().Messages.MaintenanceInfo))
+			answerPingReq(clientSocket)
+			clientSocket.Close()
+			return
+		}
+		if isMaintenance() && nextState == 2 && !isMaintenanceAdmin(playerName) {
+			logProxy.Info("player tried to join during maintenance", "player", playerName, "client_ip", clientAddress, "state", "online")
+			clientSocket.Write(buildMessage("txt", conf().Messages.MaintenanceJoin))
+			clientSocket.Close()
+			return
+		}
+
 		// if the server is online, just open a connection with the server and connect it with the client
-		serverSocket, err := net.Dial("tcp", targetHost+":"+targetPort)
+		serverSocket, err := net.Dial("tcp", conf().Advanced.TargetHost+":"+conf().Advanced.TargetPort)
 		if err != nil {
-			logger("handleClientSocket: error during serverSocket.Dial()")
+			logProxy.Warn("handleClientSocket: error while connecting to the minecraft server", "client_ip", clientAddress, "error", err)
+			clientSocket.Close()
 			return
 		}
 
-		connectSocketsAsync(clientSocket, serverSocket)
+		// forward the packets already read
+		serverSocket.Write(data)
+
+		kind := "status"
+		if nextState == 2 {
+			kind = "join"
+		}
+		s := openSession(kind, playerName, clientAddress)
+		s.addBytes(len(data), false)
+
+		connectSocketsAsync(clientSocket, serverSocket, s)
 	}
 }
 
 // launches clientToServer() and serverToClient()
-func connectSocketsAsync(client net.Conn, server net.Conn) {
-	go clientToServer(client, server)
-	go serverToClient(server, client)
+func connectSocketsAsync(client net.Conn, server net.Conn, s *session) {
+	go clientToServer(client, server, s)
+	go serverToClient(server, client, s)
 }
 
-func clientToServer(source, destination net.Conn) {
+func clientToServer(source, destination net.Conn, s *session) {
 	players++
-	log.Printf("*** A PLAYER JOINED THE SERVER! - %d players online", players)
+	if s.Kind == "join" {
+		logProxy.Info(s.PlayerName+" JOINED THE SERVER!", "player", s.PlayerName, "client_ip", s.ClientAddress, "conn_id", s.ID, "players", players)
+		publishEvent(event{Type: "player_join", Player: s.PlayerName, ClientAddress: s.ClientAddress})
+		recordJoinHistory()
+	} else {
+		logProxy.Info("A PLAYER JOINED THE SERVER!", "client_ip", s.ClientAddress, "conn_id", s.ID, "players", players)
+	}
 
 	// exchanges data from client to server (isServerToClient == false)
-	forwardSync(source, destination, false)
+	forwardSync(source, destination, false, s)
 
+	s.close()
 	players--
-	log.Printf("*** A PLAYER LEFT THE SERVER! - %d players online", players)
+	if s.Kind == "join" {
+		logProxy.Info(s.PlayerName+" LEFT THE SERVER!", "player", s.PlayerName, "client_ip", s.ClientAddress, "conn_id", s.ID, "players", players)
+		publishEvent(event{Type: "player_leave", Player: s.PlayerName, ClientAddress: s.ClientAddress})
+	} else {
+		logProxy.Info("A PLAYER LEFT THE SERVER!", "client_ip", s.ClientAddress, "conn_id", s.ID, "players", players)
+	}
 
 	// this block increases stopInstances by one and starts the timer to execute stopEmptyMinecraftServer(false)
 	// (that will do nothing in case there are players online)
 	mutex.Lock()
 	stopInstances++
 	mutex.Unlock()
-	time.AfterFunc(time.Duration(timeBeforeStoppingEmptyServer)*time.Second, func() { stopEmptyMinecraftServer(false) })
+	time.AfterFunc(time.Duration(conf().Basic.TimeBeforeStoppingEmptyServer)*time.Second, func() { stopEmptyMinecraftServer(false) })
 }
 
-func serverToClient(source, destination net.Conn) {
+func serverToClient(source, destination net.Conn, s *session) {
 	// exchanges data from server to client (isServerToClient == true)
-	forwardSync(source, destination, true)
+	forwardSync(source, destination, true, s)
 }
 
 // forwardSync takes a source and a destination net.Conn and forwards them.
-// (isServerToClient used to know the forward direction)
-func forwardSync(source, destination net.Conn, isServerToClient bool) {
+// (isServerToClient used to know the forward direction, s is the session to which the bytes are counted)
+func forwardSync(source, destination net.Conn, isServerToClient bool, s *session) {
 	data := make([]byte, 1024)
 
 	// set to false after the first for cycle
@@ -371,17 +914,30 @@ func forwardSync(source, destination net.Conn, isServerToClient bool) {
 
 	for {
 		// update read and write timeout
-		source.SetReadDeadline(time.Now().Add(time.Duration(timeBeforeStoppingEmptyServer) * time.Second))
-		destination.SetWriteDeadline(time.Now().Add(time.Duration(timeBeforeStoppingEmptyServer) * time.Second))
+		source.SetReadDeadline(time.Now().Add(time.Duration(conf().Basic.TimeBeforeStoppingEmptyServer) * time.Second))
+		destination.SetWriteDeadline(time.Now().Add(time.Duration(conf().Basic.TimeBeforeStoppingEmptyServer) * time.Second))
 
 		// read data from source
 		dataLen, err := source.Read(data)
 		if err != nil {
+			// keep track of why the session ended (the direction that stops first knows it)
+			var netErr net.Error
+			switch {
+			case err == io.EOF && isServerToClient:
+				s.setDisconnectReason("server closed the connection")
+			case err == io.EOF:
+				s.setDisconnectReason("client closed the connection")
+			case errors.As(err, &netErr) && netErr.Timeout():
+				s.setDisconnectReason("timeout")
+			case !strings.Contains(err.Error(), "use of closed network connection"):
+				s.setDisconnectReason(err.Error())
+			}
+
 			// case in which the connection is closed by the source or closed by target
 			if err == io.EOF || strings.Contains(err.Error(), "use of closed network connection") {
-				logger(fmt.Sprintf("closing %s --> %s because of: %s", strings.Split(source.RemoteAddr().String(), ":")[0], strings.Split(destination.RemoteAddr().String(), ":")[0], err.Error()))
+				logProxy.Debug("closing connection", "conn_id", s.ID, "from", source.RemoteAddr().String(), "to", destination.RemoteAddr().String(), "reason", err.Error())
 			} else {
-				logger(fmt.Sprintf("forwardSync: error in forward(): %v\n%s --> %s", err, strings.Split(source.RemoteAddr().String(), ":")[0], strings.Split(destination.RemoteAddr().String(), ":")[0]))
+				logProxy.Debug("forwardSync: error in forward()", "conn_id", s.ID, "from", source.RemoteAddr().String(), "to", destination.RemoteAddr().String(), "error", err)
 			}
 
 			// close the source connection
@@ -391,9 +947,10 @@ func forwardSync(source, destination net.Conn, isServerToClient bool) {
 
 		// write data to destination
 		destination.Write(data[:dataLen])
+		s.addBytes(dataLen, isServerToClient)
 
-		// if debug == true --> calculate bytes/s to client/server
-		if debug {
+		// if the proxy debug log is enabled --> calculate bytes/s to client/server
+		if logProxy.Enabled(context.Background(), slog.LevelDebug) {
 			mutex.Lock()
 			if isServerToClient {
 				dataCountBytesToClients = dataCountBytesToClients + float64(dataLen)
@@ -415,11 +972,7 @@ func forwardSync(source, destination net.Conn, isServerToClient bool) {
 				serverVersion = newServerVersion
 				serverProtocol = newServerProtocol
 
-				logger(
-					"server version found!",
-					"serverVersion:", serverVersion,
-					"serverProtocol:", serverProtocol,
-				)
+				logProtocol.Debug("server version found!", "server_version", serverVersion, "server_protocol", serverProtocol)
 			}
 		}
 
@@ -430,6 +983,12 @@ func forwardSync(source, destination net.Conn, isServerToClient bool) {
 
 //---------------------------utils----------------------------//
 
+// clientAddressOf returns the ip of the client connected to conn (also ipv6 addresses)
+func clientAddressOf(conn net.Conn) string {
+	var lastIndex int = strings.LastIndex(conn.RemoteAddr().String(), ":")
+	return conn.RemoteAddr().String()[:lastIndex]
+}
+
 // takes the format ("txt", "info") and a message to write to the client
 func buildMessage(format, message string) []byte {
 	var mountHeader = func(messageStr string, constant int) []byte {
@@ -489,9 +1048,15 @@ func buildMessage(format, message string) []byte {
 		// in message: "\n" -> "&r\\n" then "&" -> "\xc2\xa7"
 		messageAdapted := strings.ReplaceAll(strings.ReplaceAll(message, "\n", "&r\\n"), "&", "\xc2\xa7")
 
+		// during maintenance the client shows the maintenance version text (the protocol never matches)
+		version, protocol := serverVersion, serverProtocol
+		if isMaintenance() {
+			version, protocol = conf().Messages.MaintenanceVersion, "-1"
+		}
+
 		messageJSON := fmt.Sprint("{",
 			"\"description\":{\"text\":\"", messageAdapted, "\"},",
-			"\"version\":{\"name\":\"", serverVersion, "\",\"protocol\":", serverProtocol, "},",
+			"\"version\":{\"name\":\"", version, "\",\"protocol\":", protocol, "},",
 			"\"favicon\":\"", serverIcon, "\"",
 			"}",
 		)
@@ -500,7 +1065,7 @@ func buildMessage(format, message string) []byte {
 		messageHeader = mountHeader(messageJSON, 11264)
 
 	} else {
-		logger("buildMessage: specified format invalid")
+		logProtocol.Error("buildMessage: specified format invalid", "format", format)
 		messageHeader = nil
 	}
 
@@ -513,7 +1078,7 @@ func answerPingReq(clientSocket net.Conn) {
 	// read the first packet
 	dataLen, err := clientSocket.Read(req)
 	if err != nil {
-		logger("answerPingReq: error while reading [1] ping request:", err.Error())
+		logProtocol.Debug("answerPingReq: error while reading [1] ping request", "error", err)
 		return
 	}
 
@@ -521,7 +1086,7 @@ func answerPingReq(clientSocket net.Conn) {
 	if bytes.Equal(req[:dataLen], []byte{1, 0}) {
 		dataLen, err = clientSocket.Read(req)
 		if err != nil {
-			logger("answerPingReq: error while reading [2] ping request:", err.Error())
+			logProtocol.Debug("answerPingReq: error while reading [2] ping request", "error", err)
 			return
 		}
 	} else if bytes.Equal(req[:2], []byte{1, 0}) {
@@ -536,20 +1101,13 @@ func answerPingReq(clientSocket net.Conn) {
 	clientSocket.Write(req[:dataLen])
 }
 
-// prints the args if debug option is set to true
-func logger(args ...string) {
-	if debug {
-		log.Println(strings.Join(args, " "))
-	}
-}
-
 //------------------------go specific-------------------------//
 
 var cmdIn io.WriteCloser
 
 // initializes some variables
 func initVariables() {
-	timeLeftUntilUp = minecraftServerStartupTime
+	timeLeftUntilUp = conf().Basic.MinecraftServerStartupTime
 }
 
 //---------------------------data-----------------------------//
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mqtt packet types (already shifted in the high nibble of the fixed header)
+const (
+	mqttConnect    = 0x10
+	mqttConnack    = 0x20
+	mqttPublish    = 0x30
+	mqttPuback     = 0x40
+	mqttSubscribe  = 0x82 // subscribe needs the flags 0010
+	mqttPingreq    = 0xC0
+	mqttKeepAlive  = 60 // seconds
+	mqttPingPeriod = 30 * time.Second
+)
+
+// mqttConnackErrors contains the reasons of a refused connection (connack return codes 1-5)
+var mqttConnackErrors = []string{"", "unacceptable protocol version", "client id rejected", "server unavailable", "bad username or password", "not authorized"}
+
+// mqttClient is a connection to the mqtt broker (mqtt 3.1.1, only qos 0 is published)
+type mqttClient struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	writeMutex sync.Mutex
+	packetID   uint16
+}
+
+// mqttTopics are the topics used by msh, all under Mqtt.TopicPrefix
+type mqttTopics struct {
+	Availability string // "online" while msh is connected, "offline" (last will) when it disconnects
+	State        string // offline, starting, online, stopping
+	Players      string // number of players online
+	PlayerList   string // json array with the names of the players online
+	Command      string // msh subscribes to it: accepts "start" and "stop"
+}
+
+// startMqtt launches the goroutine that keeps msh connected to the mqtt broker (if enabled)
+func startMqtt() {
+	if !conf().Mqtt.Enabled {
+		return
+	}
+	// the events are only needed while connected (on connect the current state is published, retained),
+	// so the subscription is lossy: a broker down for hours doesn't queue all the events in memory
+	events, _ := subscribeEvents("starting", "online", "stopping", "offline", "player_join", "player_leave")
+	go func() {
+		retryDelay := 5 * time.Second
+		for {
+			client, err := dialMqtt()
+			if err != nil {
+				logMsh.Warn("startMqtt: connection failed", "error", err, "retry_in", retryDelay.String())
+				time.Sleep(retryDelay)
+				retryDelay = min(2*retryDelay, time.Minute)
+				continue
+			}
+			retryDelay = 5 * time.Second
+			logMsh.Info("connected to mqtt broker", "broker", conf().Mqtt.Broker)
+
+			err = client.serve(events)
+			client.conn.Close()
+			logMsh.Warn("mqtt connection lost", "error", err)
+		}
+	}()
+}
+
+// getMqttTopics returns the topics built from Mqtt.TopicPrefix
+func getMqttTopics() mqttTopics {
+	prefix := strings.TrimSuffix(conf().Mqtt.TopicPrefix, "/")
+	return mqttTopics{
+		Availability: prefix + "/availability",
+		State:        prefix + "/state",
+		Players:      prefix + "/players",
+		PlayerList:   prefix + "/player_list",
+		Command:      prefix + "/command",
+	}
+}
+
+// dialMqtt connects to the broker, with the last will that sets the availability topic to "offline"
+func dialMqtt() (*mqttClient, error) {
+	c := conf().Mqtt
+	conn, err := net.DialTimeout("tcp", c.Broker, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: %v", err)
+	}
+	client := &mqttClient{conn: conn, reader: bufio.NewReader(conn)}
+
+	// connect flags: clean session, will retained with qos 1, username and password if set
+	var flags byte = 0x02 | 0x04 | 0x08 | 0x20
+	var payload bytes.Buffer
+	writeMqttString(&payload, c.ClientID)
+	writeMqttString(&payload, getMqttTopics().Availability)
+	writeMqttString(&payload, "offline")
+	if c.Username != "" {
+		flags |= 0x80
+		writeMqttString(&payload, c.Username)
+		if c.Password != "" {
+			flags |= 0x40
+			writeMqttString(&payload, c.Password)
+		}
+	}
+
+	var body bytes.Buffer
+	writeMqttString(&body, "MQTT")
+	body.WriteByte(4) // protocol level 3.1.1
+	body.WriteByte(flags)
+	binary.Write(&body, binary.BigEndian, uint16(mqttKeepAlive))
+	body.Write(payload.Bytes())
+
+	if err := client.writePacket(mqttConnect, body.Bytes()); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	header, connack, err := client.readPacket()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt: %v", err)
+	}
+	if header&0xF0 != mqttConnack || len(connack) != 2 {
+		conn.Close()
+		return nil, errors.New("mqtt: unexpected answer to connect")
+	}
+	if returnCode := int(connack[1]); returnCode != 0 {
+		conn.Close()
+		if returnCode < len(mqttConnackErrors) {
+			return nil, errors.New("mqtt: connection refused: " + mqttConnackErrors[returnCode])
+		}
+		return nil, fmt.Errorf("mqtt: connection refused (code %d)", returnCode)
+	}
+	return client, nil
+}
+
+// serve publishes the current state, subscribes to the command topic and then publishes the state changes
+// received from events until the connection is lost
+func (c *mqttClient) serve(events chan event) error {
+	topics := getMqttTopics()
+
+	// the events buffered while disconnected are outdated: the current state is published anyway
+	for len(events) > 0 {
+		<-events
+	}
+
+	if err := c.publish(topics.Availability, "online", true); err != nil {
+		return err
+	}
+	if conf().Mqtt.DiscoveryPrefix != "" {
+		if err := c.publishDiscovery(topics); err != nil {
+			return err
+		}
+	}
+	if err := c.publish(topics.State, serverStatus, true); err != nil {
+		return err
+	}
+	if err := c.publishPlayers(topics); err != nil {
+		return err
+	}
+	if err := c.subscribe(topics.Command); err != nil {
+		return err
+	}
+
+	readErr := make(chan error, 1)
+	go func() { readErr <- c.readLoop(topics.Command) }()
+
+	ping := time.NewTicker(mqttPingPeriod)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case err = <-readErr:
+			return err
+		case <-ping.C:
+			err = c.writePacket(mqttPingreq, nil)
+		case e := <-events:
+			switch e.Type {
+			case "starting", "online", "stopping", "offline":
+				err = c.publish(topics.State, e.Type, true)
+				if err == nil && e.Type == "offline" {
+					err = c.publishPlayers(topics)
+				}
+			case "player_join", "player_leave":
+				err = c.publishPlayers(topics)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// publishPlayers publishes the number and the names of the players online
+func (c *mqttClient) publishPlayers(topics mqttTopics) error {
+	names := onlinePlayerNames()
+	if names == nil {
+		names = []string{}
+	}
+	if err := c.publish(topics.Players, fmt.Sprint(len(names)), true); err != nil {
+		return err
+	}
+	list, _ := json.Marshal(names)
+	return c.publish(topics.PlayerList, string(list), true)
+}
+
+// publishDiscovery publishes the home assistant discovery configs: state, players and player list sensors,
+// running binary sensor, start and stop buttons
+func (c *mqttClient) publishDiscovery(topics mqttTopics) error {
+	nodeID := regexp.MustCompile(`[^a-zA-Z0-9_-]`).ReplaceAllString(conf().Mqtt.ClientID, "_")
+	device := map[string]interface{}{
+		"identifiers":  []string{"msh_" + nodeID},
+		"name":         "Minecraft server (" + conf().Mqtt.ClientID + ")",
+		"manufacturer": "minecraft-server-hibernation",
+		"sw_version":   info[2],
+	}
+
+	components := []struct {
+		component string
+		object    string
+		config    map[string]interface{}
+	}{
+		{"sensor", "state", map[string]interface{}{"name": "State", "state_topic": topics.State, "icon": "mdi:minecraft"}},
+		{"sensor", "players", map[string]interface{}{"name": "Players", "state_topic": topics.Players, "unit_of_measurement": "players", "state_class": "measurement", "icon": "mdi:account-multiple"}},
+		{"sensor", "player_list", map[string]interface{}{"name": "Player list", "state_topic": topics.PlayerList, "value_template": "{{ value_json | join(', ') }}", "icon": "mdi:account-details"}},
+		{"binary_sensor", "running", map[string]interface{}{"name": "Running", "state_topic": topics.State, "value_template": "{{ 'ON' if value == 'online' else 'OFF' }}", "device_class": "running"}},
+		{"button", "start", map[string]interface{}{"name": "Start", "command_topic": topics.Command, "payload_press": "start", "icon": "mdi:play"}},
+		{"button", "stop", map[string]interface{}{"name": "Stop", "command_topic": topics.Command, "payload_press": "stop", "icon": "mdi:stop"}},
+	}
+
+	for _, component := range components {
+		component.config["unique_id"] = "msh_" + nodeID + "_" + component.object
+		component.config["availability_topic"] = topics.Availability
+		component.config["device"] = device
+		payload, err := json.Marshal(component.config)
+		if err != nil {
+			return err
+		}
+		topic := strings.TrimSuffix(conf().Mqtt.DiscoveryPrefix, "/") + "/" + component.component + "/" + nodeID + "/" + component.object + "/config"
+		if err := c.publish(topic, string(payload), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLoop reads the packets sent by the broker and executes the messages received on the command topic
+func (c *mqttClient) readLoop(commandTopic string) error {
+	for {
+		// the broker answers the pings: if nothing is received the connection is lost
+		c.conn.SetReadDeadline(time.Now().Add(2 * mqttPingPeriod))
+		header, body, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		if header&0xF0 != mqttPublish {
+			continue
+		}
+
+		// publish: [topic length uint16][topic][packet id uint16 if qos > 0][payload]
+		if len(body) < 2 {
+			return errors.New("malformed publish packet")
+		}
+		topicLen := int(binary.BigEndian.Uint16(body))
+		if len(body) < 2+topicLen {
+			return errors.New("malformed publish packet")
+		}
+		topic := string(body[2 : 2+topicLen])
+		payload := body[2+topicLen:]
+		if qos := (header >> 1) & 0x03; qos > 0 {
+			if len(payload) < 2 {
+				return errors.New("malformed publish packet")
+			}
+			if err := c.writePacket(mqttPuback, payload[:2]); err != nil {
+				return err
+			}
+			payload = payload[2:]
+		}
+
+		if topic == commandTopic {
+			go handleMqttCommand(strings.ToLower(strings.TrimSpace(string(payload))))
+		}
+	}
+}
+
+// handleMqttCommand executes a command received on the command topic
+func handleMqttCommand(command string) {
+	var err error
+	switch command {
+	case "start":
+		err = startMinecraftServer(event{Reason: "mqtt"})
+	case "stop":
+		err = requestStopMinecraftServer(false, "mqtt")
+	default:
+		err = errors.New("unknown command")
+	}
+	if err != nil {
+		logMsh.Warn("handleMqttCommand: command failed", "command", command, "error", err)
+		return
+	}
+	logMsh.Info("minecraft server "+command+" requested from mqtt")
+}
+
+// publish sends payload to topic with qos 0
+func (c *mqttClient) publish(topic, payload string, retain bool) error {
+	var header byte = mqttPublish
+	if retain {
+		header |= 0x01
+	}
+	var body bytes.Buffer
+	writeMqttString(&body, topic)
+	body.WriteString(payload)
+	return c.writePacket(header, body.Bytes())
+}
+
+// subscribe subscribes to topic with qos 0 (the suback is ignored by readLoop)
+func (c *mqttClient) subscribe(topic string) error {
+	c.packetID++
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, c.packetID)
+	writeMqttString(&body, topic)
+	body.WriteByte(0)
+	return c.writePacket(mqttSubscribe, body.Bytes())
+}
+
+// writePacket sends a packet: [header][remaining length][body]
+func (c *mqttClient) writePacket(header byte, body []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	packet := []byte{header}
+	// the remaining length is encoded with 7 bits per byte, the high bit means "more bytes follow"
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+// readPacket receives a packet and returns its header and body
+func (c *mqttClient) readPacket() (byte, []byte, error) {
+	header, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		digit, err := c.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7F) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// writeMqttString writes s prefixed by its length (uint16)
+func writeMqttString(buffer *bytes.Buffer, s string) {
+	binary.Write(buffer, binary.BigEndian, uint16(len(s)))
+	buffer.WriteString(s)
+}
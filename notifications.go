@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// notificationSinkTypes contains the supported kinds of notification sinks
+var notificationSinkTypes = []string{"webhook", "discord", "smtp"}
+
+// notification is a message ready to be delivered to a sink
+type notification struct {
+	Event event
+	Title string
+	Text  string
+}
+
+// notificationData is what the notification templates can use ({{.Player}}, {{.Reason}}, {{.UptimeText}}, ...)
+type notificationData struct {
+	event
+	State      string
+	Players    int
+	UptimeText string
+}
+
+// to rate limit the notifications: "sink event" -> time of the last notification sent
+var lastNotification = map[string]time.Time{}
+
+// "sink event" -> notifications not sent because of the rate limit since the last one sent
+var suppressedNotifications = map[string]int{}
+var notificationMutex = &sync.Mutex{}
+
+var notificationClient = &http.Client{Timeout: 10 * time.Second}
+
+// wait before the first retry of a failed delivery (doubled at each retry)
+var notificationRetryDelay = 5 * time.Second
+
+// startNotifications launches the goroutine that sends the notifications when an event is published
+func startNotifications() {
+	events := subscribeEventsLossless(hookEvents...)
+	go func() {
+		for e := range events {
+			for _, sink := range conf().Notifications.Sinks {
+				if !sink.notifies(e) {
+					continue
+				}
+				n, err := buildNotification(e)
+				if err != nil {
+					logMsh.Error("startNotifications: cannot build notification", "error", err)
+					continue
+				}
+				if !allowNotification(sink.Name, e.Type, &n) {
+					continue
+				}
+				go deliverNotification(sink, n)
+			}
+		}
+	}()
+}
+
+// notifiedEvents returns the events sent to the sink
+func (sink notificationSink) notifiedEvents() []string {
+	if len(sink.Events) > 0 {
+		return sink.Events
+	}
+	return conf().Notifications.Events
+}
+
+// notifies returns true if e must be sent to the sink. the "offline" that follows a crash is not sent
+// to the sinks that already got the "crashed" notification
+func (sink notificationSink) notifies(e event) bool {
+	events := sink.notifiedEvents()
+	if e.Type == "offline" && e.Reason == "crashed" && slices.Contains(events, "crashed") {
+		return false
+	}
+	return slices.Contains(events, e.Type)
+}
+
+// buildNotification renders the template of the event
+func buildNotification(e event) (notification, error) {
+	text, ok := conf().Notifications.Templates[e.Type]
+	if !ok {
+		text = "{{.Type}}"
+	}
+	tmpl, err := template.New(e.Type).Parse(text)
+	if err != nil {
+		return notification{}, fmt.Errorf("template of %s: %v", e.Type, err)
+	}
+
+	var rendered bytes.Buffer
+	err = tmpl.Execute(&rendered, notificationData{
+		event:      e,
+		State:      serverStatus,
+		Players:    players,
+		UptimeText: (time.Duration(e.Uptime) * time.Second).String(),
+	})
+	if err != nil {
+		return notification{}, fmt.Errorf("template of %s: %v", e.Type, err)
+	}
+	return notification{Event: e, Title: "msh: " + e.Type, Text: rendered.String()}, nil
+}
+
+// allowNotification returns false if a notification of the same event was sent to the sink less than
+// Notifications.MinInterval seconds ago. when a notification is allowed the number of the suppressed ones is added to it
+func allowNotification(sinkName, eventType string, n *notification) bool {
+	notificationMutex.Lock()
+	defer notificationMutex.Unlock()
+
+	key := sinkName + " " + eventType
+	if time.Since(lastNotification[key]) < time.Duration(conf().Notifications.MinInterval)*time.Second {
+		suppressedNotifications[key]++
+		recordNotification(sinkName, "suppressed")
+		logMsh.Debug("allowNotification: notification suppressed by rate limit", "event", eventType, "sink", sinkName)
+		return false
+	}
+	if suppressed := suppressedNotifications[key]; suppressed > 0 {
+		n.Text += fmt.Sprintf(" (%d similar notifications suppressed)", suppressed)
+	}
+	lastNotification[key] = time.Now()
+	suppressedNotifications[key] = 0
+	return true
+}
+
+// deliverNotification sends n to sink, trying again up to Notifications.Retries times (waiting longer each time)
+func deliverNotification(sink notificationSink, n notification) {
+	var err error
+	retryDelay := notificationRetryDelay
+	for attempt := 0; attempt <= conf().Notifications.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryDelay)
+			retryDelay *= 2
+		}
+		err = sendNotification(sink, n)
+		if err == nil {
+			logMsh.Debug("deliverNotification: notification sent", "event", n.Event.Type, "sink", sink.Name)
+			recordNotification(sink.Name, "sent")
+			return
+		}
+		logMsh.Debug("deliverNotification: attempt failed", "event", n.Event.Type, "sink", sink.Name, "attempt", attempt+1, "error", err)
+	}
+	logMsh.Warn("deliverNotification: notification failed", "event", n.Event.Type, "sink", sink.Name, "error", err)
+	recordNotification(sink.Name, "failed")
+}
+
+// sendNotification makes a single attempt to send n to sink
+func sendNotification(sink notificationSink, n notification) error {
+	switch sink.Type {
+	case "webhook":
+		return postNotification(sink.URL, map[string]interface{}{
+			"event":         n.Event.Type,
+			"time":          n.Event.Time,
+			"player":        n.Event.Player,
+			"clientAddress": n.Event.ClientAddress,
+			"reason":        n.Event.Reason,
+			"uptime":        n.Event.Uptime,
+			"title":         n.Title,
+			"text":          n.Text,
+		})
+	case "discord":
+		return postNotification(sink.URL, map[string]string{"username": "msh", "content": n.Text})
+	case "smtp":
+		return mailNotification(sink, n)
+	}
+	return fmt.Errorf("unknown sink type %q", sink.Type)
+}
+
+// postNotification sends payload as json to url
+func postNotification(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	response, err := notificationClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s", url, response.Status)
+	}
+	return nil
+}
+
+// mailNotification sends n by email using the smtp server sink.URL (host:port)
+func mailNotification(sink notificationSink, n notification) error {
+	var auth smtp.Auth
+	if sink.Username != "" {
+		host, _, _ := net.SplitHostPort(sink.URL)
+		auth = smtp.PlainAuth("", sink.Username, sink.Password, host)
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", sink.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(sink.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", n.Title)
+	fmt.Fprintf(&message, "Date: %s\r\n", n.Event.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(n.Text, "\n", "\r\n") + "\r\n")
+
+	return smtp.SendMail(sink.URL, auth, sink.From, sink.To, message.Bytes())
+}
+
+// sendTestNotification sends a test notification to the sink named sinkName (all sinks if empty),
+// without retries and rate limit, and returns the result for each sink
+func sendTestNotification(sinkName string) (map[string]string, error) {
+	n := notification{
+		Event: event{Type: "test", Time: time.Now()},
+		Title: "msh: test",
+		Text:  "test notification from msh",
+	}
+
+	results := map[string]string{}
+	for _, sink := range conf().Notifications.Sinks {
+		if sinkName != "" && sink.Name != sinkName {
+			continue
+		}
+		if err := sendNotification(sink, n); err != nil {
+			results[sink.Name] = err.Error()
+		} else {
+			results[sink.Name] = "sent"
+		}
+	}
+	if len(results) == 0 {
+		if sinkName != "" {
+			return nil, fmt.Errorf("unknown notification sink %q", sinkName)
+		}
+		return nil, fmt.Errorf("no notification sinks configured")
+	}
+	return results, nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useTestConfiguration makes conf() return the default configuration modified by edit until the test ends
+// (the history is disabled: the tests don't write files)
+func useTestConfiguration(t *testing.T, edit func(c *configuration)) {
+	t.Helper()
+	previous := configPointer.Load()
+	c := defaultConfiguration()
+	c.History.Enabled = false
+	if edit != nil {
+		edit(c)
+	}
+	configPointer.Store(c)
+	t.Cleanup(func() { configPointer.Store(previous) })
+}
+
+// resetNotificationState forgets the rate limit state and the notification metrics
+func resetNotificationState() {
+	notificationMutex.Lock()
+	lastNotification = map[string]time.Time{}
+	suppressedNotifications = map[string]int{}
+	notificationMutex.Unlock()
+
+	metricsMutex.Lock()
+	notificationsTotal = map[string]int64{}
+	metricsMutex.Unlock()
+}
+
+func notificationCount(sink, outcome string) int64 {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	return notificationsTotal[sink+" "+outcome]
+}
+
+func TestBuildNotificationTemplates(t *testing.T) {
+	useTestConfiguration(t, func(c *configuration) {
+		c.Notifications.Templates["online"] = "{{.Type}} for {{.Reason}}"
+		c.Notifications.Templates["wake_denied"] = "{{.Player"
+		delete(c.Notifications.Templates, "player_join")
+	})
+
+	tests := []struct {
+		e    event
+		want string
+	}{
+		{event{Type: "starting", Player: "Steve"}, "Steve woke up the server"},
+		{event{Type: "starting", Reason: "schedule"}, "Server is starting (schedule)"},
+		{event{Type: "offline", Reason: "idle", Uptime: 90}, "Server went to sleep (idle) after 1m30s"},
+		{event{Type: "online", Reason: "adopted"}, "online for adopted"},
+		// events without template are notified with their name
+		{event{Type: "player_join", Player: "Alex"}, "player_join"},
+	}
+	for _, test := range tests {
+		n, err := buildNotification(test.e)
+		if err != nil {
+			t.Errorf("buildNotification(%+v): %v", test.e, err)
+			continue
+		}
+		if n.Text != test.want {
+			t.Errorf("buildNotification(%+v).Text = %q, want %q", test.e, n.Text, test.want)
+		}
+		if n.Title != "msh: "+test.e.Type {
+			t.Errorf("buildNotification(%+v).Title = %q", test.e, n.Title)
+		}
+	}
+
+	if _, err := buildNotification(event{Type: "wake_denied"}); err == nil {
+		t.Error("buildNotification with an invalid template: expected an error")
+	}
+}
+
+func TestNotificationSinkNotifies(t *testing.T) {
+	useTestConfiguration(t, nil)
+
+	defaultSink := notificationSink{Name: "default"}
+	offlineOnly := notificationSink{Name: "offline", Events: []string{"offline"}}
+
+	tests := []struct {
+		sink notificationSink
+		e    event
+		want bool
+	}{
+		{defaultSink, event{Type: "crashed"}, true},
+		{defaultSink, event{Type: "offline", Reason: "idle"}, true},
+		// the crash was already notified
+		{defaultSink, event{Type: "offline", Reason: "crashed"}, false},
+		{defaultSink, event{Type: "player_join"}, false},
+		// the sink doesn't get "crashed": the crash is notified as "offline"
+		{offlineOnly, event{Type: "offline", Reason: "crashed"}, true},
+		{offlineOnly, event{Type: "starting"}, false},
+	}
+	for _, test := range tests {
+		if got := test.sink.notifies(test.e); got != test.want {
+			t.Errorf("sink %s notifies(%s, %s) = %v, want %v", test.sink.Name, test.e.Type, test.e.Reason, got, test.want)
+		}
+	}
+}
+
+func TestAllowNotificationRateLimit(t *testing.T) {
+	useTestConfiguration(t, func(c *configuration) { c.Notifications.MinInterval = 300 })
+	resetNotificationState()
+
+	n := notification{Text: "Server crashed"}
+	if !allowNotification("hook", "crashed", &n) {
+		t.Fatal("first notification not allowed")
+	}
+	for range 2 {
+		suppressed := notification{Text: "Server crashed"}
+		if allowNotification("hook", "crashed", &suppressed) {
+			t.Fatal("notification allowed within Notifications.MinInterval")
+		}
+	}
+	// other events and other sinks have their own limit
+	if !allowNotification("hook", "starting", &notification{}) || !allowNotification("mail", "crashed", &notification{}) {
+		t.Error("rate limit applied to another event or sink")
+	}
+	if got := notificationCount("hook", "suppressed"); got != 2 {
+		t.Errorf("suppressed notifications counted: %d, want 2", got)
+	}
+
+	// after the interval the notification is sent with the number of the suppressed ones
+	notificationMutex.Lock()
+	lastNotification["hook crashed"] = time.Now().Add(-301 * time.Second)
+	notificationMutex.Unlock()
+	n = notification{Text: "Server crashed"}
+	if !allowNotification("hook", "crashed", &n) {
+		t.Fatal("notification not allowed after Notifications.MinInterval")
+	}
+	if want := "Server crashed (2 similar notifications suppressed)"; n.Text != want {
+		t.Errorf("text = %q, want %q", n.Text, want)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	useTestConfiguration(t, nil)
+
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request %s with content type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid json: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	n, err := buildNotification(event{Type: "starting", Time: time.Now(), Player: "Steve", ClientAddress: "10.0.0.2", Reason: "join"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sendNotification(notificationSink{Name: "hook", Type: "webhook", URL: server.URL}, n); err != nil {
+		t.Fatalf("sendNotification: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"event":         "starting",
+		"player":        "Steve",
+		"clientAddress": "10.0.0.2",
+		"reason":        "join",
+		"title":         "msh: starting",
+		"text":          "Steve woke up the server",
+	}
+	for key, value := range want {
+		if received[key] != value {
+			t.Errorf("webhook %s = %v, want %v", key, received[key], value)
+		}
+	}
+}
+
+func TestDiscordSink(t *testing.T) {
+	useTestConfiguration(t, nil)
+
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		// discord answers 204 to the webhooks
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	n, err := buildNotification(event{Type: "crashed", Uptime: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sendNotification(notificationSink{Name: "discord", Type: "discord", URL: server.URL}, n); err != nil {
+		t.Fatalf("sendNotification: %v", err)
+	}
+	if received["username"] != "msh" || received["content"] != "Server crashed after 1h0m0s" {
+		t.Errorf("discord payload = %v", received)
+	}
+}
+
+func TestDeliverNotificationRetries(t *testing.T) {
+	useTestConfiguration(t, func(c *configuration) { c.Notifications.Retries = 3 })
+	resetNotificationState()
+	previousDelay := notificationRetryDelay
+	notificationRetryDelay = time.Millisecond
+	defer func() { notificationRetryDelay = previousDelay }()
+
+	// the sink fails failures times before accepting the notification
+	var newSink = func(failures int32) (*httptest.Server, *atomic.Int32) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if attempts.Add(1) <= failures {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+		return server, &attempts
+	}
+	n := notification{Event: event{Type: "crashed"}, Title: "msh: crashed", Text: "Server crashed"}
+
+	server, attempts := newSink(2)
+	defer server.Close()
+	deliverNotification(notificationSink{Name: "flaky", Type: "webhook", URL: server.URL}, n)
+	if attempts.Load() != 3 || notificationCount("flaky", "sent") != 1 {
+		t.Errorf("flaky sink: %d attempts, %d sent (want 3 attempts, 1 sent)", attempts.Load(), notificationCount("flaky", "sent"))
+	}
+
+	server, attempts = newSink(100)
+	defer server.Close()
+	deliverNotification(notificationSink{Name: "down", Type: "webhook", URL: server.URL}, n)
+	if attempts.Load() != 4 || notificationCount("down", "failed") != 1 || notificationCount("down", "sent") != 0 {
+		t.Errorf("down sink: %d attempts, %d failed (want 4 attempts, 1 failed)", attempts.Load(), notificationCount("down", "failed"))
+	}
+}
+
+func TestSmtpSink(t *testing.T) {
+	useTestConfiguration(t, nil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// minimal smtp server: accepts one message (without STARTTLS and AUTH) and sends the dialog on the channel
+	dialog := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var lines []string
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP test")
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case inData && line == ".":
+				inData = false
+				reply("250 queued")
+			case inData:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250 localhost")
+			case line == "DATA":
+				inData = true
+				reply("354 end with <CRLF>.<CRLF>")
+			case line == "QUIT":
+				reply("221 bye")
+				dialog <- lines
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+		dialog <- lines
+	}()
+
+	sink := notificationSink{
+		Name: "mail",
+		Type: "smtp",
+		URL:  listener.Addr().String(),
+		From: "msh@example.com",
+		To:   []string{"me@example.com", "you@example.com"},
+	}
+	n, err := buildNotification(event{Type: "offline", Time: time.Now(), Reason: "idle", Uptime: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sendNotification(sink, n); err != nil {
+		t.Fatalf("sendNotification: %v", err)
+	}
+
+	var lines []string
+	select {
+	case lines = <-dialog:
+	case <-time.After(5 * time.Second):
+		t.Fatal("smtp dialog not finished")
+	}
+	text := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"MAIL FROM:<msh@example.com>",
+		"RCPT TO:<me@example.com>",
+		"RCPT TO:<you@example.com>",
+		"From: msh@example.com",
+		"To: me@example.com, you@example.com",
+		"Subject: msh: offline",
+		"Server went to sleep (idle) after 1m0s",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("smtp dialog doesn't contain %q:\n%s", want, text)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// prewarmModel is the probability that a player joins in each hour of the week, learned from the history
+// (weekday and hour in Schedule.Timezone)
+type prewarmModel struct {
+	BuiltAt      time.Time `json:"builtAt"`
+	LearnedSince time.Time `json:"learnedSince"`
+	// [weekday][hour]: hours observed and hours in which at least a player joined
+	Samples     [7][24]int     `json:"samples"`
+	Joins       [7][24]int     `json:"joins"`
+	Probability [7][24]float64 `json:"probability"`
+}
+
+// prewarmDecision is what the predictor decided for an hour with a join probability over the threshold
+type prewarmDecision struct {
+	Time        time.Time `json:"time"`
+	Slot        time.Time `json:"slot"`
+	Probability float64   `json:"probability"`
+	Action      string    `json:"action"` // "started" or "skipped"
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// prewarmInfo is the state of the predictor shown by the "prewarm" command
+type prewarmInfo struct {
+	Enabled          bool              `json:"enabled"`
+	Threshold        float64           `json:"threshold"`
+	BudgetHours      float64           `json:"budgetHours"`
+	SpeculativeHours float64           `json:"speculativeHours"` // in the last 7 days
+	HoldUntil        time.Time         `json:"holdUntil,omitzero"`
+	NextSlot         time.Time         `json:"nextSlot,omitzero"` // next hour over the threshold (in the next 7 days)
+	Model            prewarmModel      `json:"model"`
+	Decisions        []prewarmDecision `json:"decisions"`
+}
+
+// hours of a slot needed before its probability is used
+const prewarmMinSamples = 2
+
+// number of decisions kept in memory
+const prewarmMaxDecisions = 50
+
+// to keep track of the predictor
+var currentPrewarmModel *prewarmModel
+var prewarmDecisions []prewarmDecision
+var prewarmMutex = &sync.Mutex{}
+
+// last hour for which a decision was taken
+var prewarmLastSlot time.Time
+
+// the current prewarm wake: when the server was started and when the first player joined (zero: nobody yet)
+var prewarmStarted, prewarmFirstJoin time.Time
+
+// the server started by the predictor is kept running (even if empty) until prewarmHoldUntil
+var prewarmHoldUntil time.Time
+
+// isPrewarmHolding returns true if the server was started by the predictor and must not be stopped yet
+func isPrewarmHolding() bool {
+	prewarmMutex.Lock()
+	defer prewarmMutex.Unlock()
+	return !prewarmHoldUntil.IsZero()
+}
+
+// buildPrewarmModel learns from the last Prewarm.Days days of history in which hours of the week players join
+func buildPrewarmModel(now time.Time) (*prewarmModel, error) {
+	c := conf()
+	location := c.scheduleLocation()
+	learnStart := now.AddDate(0, 0, -c.Prewarm.Days)
+
+	records, err := readHistory(learnStart)
+	if err != nil {
+		return nil, err
+	}
+
+	var hourStart = func(t time.Time) time.Time {
+		t = t.In(location)
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, location)
+	}
+
+	// hours in which a player joined (or tried to wake the server), by unix time
+	joined := map[int64]bool{}
+	var firstRecord time.Time
+	for _, record := range records {
+		if firstRecord.IsZero() || record.Since.Before(firstRecord) {
+			firstRecord = record.Since
+		}
+		if record.Since.Before(learnStart) {
+			continue
+		}
+		if record.Type == "session" || (record.Type == "wake" && record.Player != "") {
+			joined[hourStart(record.Since).Unix()] = true
+		}
+	}
+
+	// only the hours in which msh was recording are observed
+	if firstRecord.After(learnStart) {
+		learnStart = firstRecord
+	}
+	model := &prewarmModel{BuiltAt: now, LearnedSince: learnStart}
+	for hour := hourStart(learnStart); hour.Before(hourStart(now)); hour = hour.Add(time.Hour) {
+		weekday, h := hour.Weekday(), hour.Hour()
+		model.Samples[weekday][h]++
+		if joined[hour.Unix()] {
+			model.Joins[weekday][h]++
+		}
+	}
+	for weekday := range model.Samples {
+		for h, samples := range model.Samples[weekday] {
+			if samples > 0 {
+				model.Probability[weekday][h] = float64(model.Joins[weekday][h]) / float64(samples)
+			}
+		}
+	}
+	return model, nil
+}
+
+// getPrewarmModel returns the model, learning it again if it is older than an hour
+func getPrewarmModel(now time.Time) (*prewarmModel, error) {
+	prewarmMutex.Lock()
+	model := currentPrewarmModel
+	prewarmMutex.Unlock()
+	if model != nil && now.Sub(model.BuiltAt) < time.Hour {
+		return model, nil
+	}
+
+	model, err := buildPrewarmModel(now)
+	if err != nil {
+		return nil, err
+	}
+	prewarmMutex.Lock()
+	currentPrewarmModel = model
+	prewarmMutex.Unlock()
+	return model, nil
+}
+
+// probability returns the join probability of the hour starting at slot (0 if the hour was not observed enough)
+func (model *prewarmModel) probability(slot time.Time) float64 {
+	slot = slot.In(conf().scheduleLocation())
+	if model.Samples[slot.Weekday()][slot.Hour()] < prewarmMinSamples {
+		return 0
+	}
+	return model.Probability[slot.Weekday()][slot.Hour()]
+}
+
+// speculativeHours returns the hours the server ran in the last 7 days after being started by the predictor
+// before a player joined (the whole wake if nobody joined)
+func speculativeHours(now time.Time) (float64, error) {
+	weekAgo := now.AddDate(0, 0, -7)
+	records, err := readHistory(weekAgo)
+	if err != nil {
+		return 0, err
+	}
+
+	hours := 0.0
+	for _, wake := range records {
+		if wake.Type != "wake" || wake.Reason != "prewarm" {
+			continue
+		}
+		end := wake.Until
+		for _, session := range records {
+			if session.Type == "session" && !session.Since.Before(wake.Since) && session.Since.Before(end) {
+				end = session.Since
+			}
+		}
+		hours += max(end.Sub(maxTime(wake.Since, weekAgo)).Hours(), 0)
+	}
+
+	// the current wake is written in the history only when the server stops
+	prewarmMutex.Lock()
+	if !prewarmStarted.IsZero() {
+		end := now
+		if !prewarmFirstJoin.IsZero() {
+			end = prewarmFirstJoin
+		}
+		hours += end.Sub(prewarmStarted).Hours()
+	}
+	prewarmMutex.Unlock()
+
+	return hours, nil
+}
+
+// watchPrewarm applies the predictor every 30 seconds
+func watchPrewarm() {
+	for range time.Tick(30 * time.Second) {
+		applyPrewarm()
+	}
+}
+
+// applyPrewarm starts the server {Prewarm.Lead} minutes before an hour in which players are likely to join
+// and releases the server when the hour ends or the budget is exhausted
+func applyPrewarm() {
+	c := conf()
+	now := time.Now()
+
+	prewarmMutex.Lock()
+	if serverStatus == "offline" {
+		prewarmStarted, prewarmFirstJoin = time.Time{}, time.Time{}
+	}
+	if !prewarmStarted.IsZero() && prewarmFirstJoin.IsZero() && players > 0 {
+		prewarmFirstJoin = now
+	}
+	holding, speculating := !prewarmHoldUntil.IsZero(), !prewarmStarted.IsZero() && prewarmFirstJoin.IsZero()
+	prewarmMutex.Unlock()
+
+	// the budget is checked only while the server runs without players (the history is read)
+	budgetExhausted := false
+	if holding && speculating {
+		used, err := speculativeHours(now)
+		if err != nil {
+			logMsh.Warn("applyPrewarm: error while reading the history", "error", err)
+		}
+		budgetExhausted = err == nil && used >= c.Prewarm.BudgetHours
+	}
+
+	release := false
+	prewarmMutex.Lock()
+	if !prewarmHoldUntil.IsZero() && (now.After(prewarmHoldUntil) || serverStatus == "offline" || !c.Prewarm.Enabled || budgetExhausted) {
+		prewarmHoldUntil = time.Time{}
+		release = serverStatus == "online"
+		logMsh.Info("prewarm hold released", "budget_exhausted", budgetExhausted, "state", serverStatus)
+	}
+	prewarmMutex.Unlock()
+
+	// the server is not needed anymore: stop it if it's empty
+	if release {
+		mutex.Lock()
+		stopInstances++
+		mutex.Unlock()
+		time.AfterFunc(time.Duration(c.Basic.TimeBeforeStoppingEmptyServer)*time.Second, func() { stopEmptyMinecraftServer(false) })
+	}
+
+	if !c.Prewarm.Enabled {
+		return
+	}
+
+	// the next hour is the only one considered, once, starting {Lead} minutes before it
+	local := now.In(c.scheduleLocation())
+	slot := time.Date(local.Year(), local.Month(), local.Day(), local.Hour()+1, 0, 0, 0, local.Location())
+	prewarmMutex.Lock()
+	alreadyDecided := !slot.After(prewarmLastSlot)
+	prewarmMutex.Unlock()
+	if alreadyDecided || slot.Sub(now) > time.Duration(c.Prewarm.Lead)*time.Minute {
+		return
+	}
+
+	model, err := getPrewarmModel(now)
+	if err != nil {
+		logMsh.Warn("applyPrewarm: error while learning the model", "error", err)
+		return
+	}
+	probability := model.probability(slot)
+	if probability < c.Prewarm.Threshold {
+		return
+	}
+	used, err := speculativeHours(now)
+	if err != nil {
+		logMsh.Warn("applyPrewarm: error while reading the history", "error", err)
+		return
+	}
+
+	decision := prewarmDecision{Time: now, Slot: slot, Probability: probability, Action: "skipped"}
+	switch {
+	case isMaintenance():
+		decision.Reason = "maintenance"
+	case scheduleMode(now) == "closed" || scheduleMode(slot) == "closed":
+		decision.Reason = "schedule closed"
+	case scheduleMode(slot) == "always_on":
+		decision.Reason = "schedule always on"
+	case serverStatus != "offline":
+		decision.Reason = "server already running"
+	case used >= c.Prewarm.BudgetHours:
+		decision.Reason = fmt.Sprintf("budget exhausted (%.1f/%.1f hours)", used, c.Prewarm.BudgetHours)
+	default:
+		if err := startMinecraftServer(event{Reason: "prewarm"}); err != nil {
+			decision.Reason = err.Error()
+			break
+		}
+		decision.Action = "started"
+	}
+
+	prewarmMutex.Lock()
+	prewarmLastSlot = slot
+	if decision.Action == "started" {
+		prewarmStarted, prewarmFirstJoin = now, time.Time{}
+		prewarmHoldUntil = slot.Add(time.Hour)
+	}
+	prewarmDecisions = append(prewarmDecisions, decision)
+	if len(prewarmDecisions) > prewarmMaxDecisions {
+		prewarmDecisions = prewarmDecisions[len(prewarmDecisions)-prewarmMaxDecisions:]
+	}
+	prewarmMutex.Unlock()
+
+	logMsh.Info("prewarm decision", "slot", slot.Format("Mon 15:04"), "probability", probability, "action", decision.Action, "reason", decision.Reason)
+}
+
+// getPrewarmInfo returns the model, the budget and the decisions of the predictor
+func getPrewarmInfo() (prewarmInfo, error) {
+	c := conf()
+	now := time.Now()
+
+	model, err := getPrewarmModel(now)
+	if err != nil {
+		return prewarmInfo{}, err
+	}
+	used, err := speculativeHours(now)
+	if err != nil {
+		return prewarmInfo{}, err
+	}
+
+	info := prewarmInfo{
+		Enabled:          c.Prewarm.Enabled,
+		Threshold:        c.Prewarm.Threshold,
+		BudgetHours:      c.Prewarm.BudgetHours,
+		SpeculativeHours: used,
+		Model:            *model,
+	}
+	local := now.In(c.scheduleLocation())
+	for h := 1; h <= 7*24; h++ {
+		slot := time.Date(local.Year(), local.Month(), local.Day(), local.Hour()+h, 0, 0, 0, local.Location())
+		if model.probability(slot) >= c.Prewarm.Threshold {
+			info.NextSlot = slot
+			break
+		}
+	}
+
+	prewarmMutex.Lock()
+	info.HoldUntil = prewarmHoldUntil
+	info.Decisions = append([]prewarmDecision{}, prewarmDecisions...)
+	prewarmMutex.Unlock()
+
+	return info, nil
+}
+
+// printPrewarmInfo prints the predictor state received from the running msh
+func printPrewarmInfo(data interface{}) {
+	var info prewarmInfo
+	encoded, _ := json.Marshal(data)
+	if err := json.Unmarshal(encoded, &info); err != nil {
+		fmt.Println(string(encoded))
+		return
+	}
+
+	fmt.Printf("enabled: %v, threshold: %.0f%%, speculative hours (7 days): %.1f/%.1f\n", info.Enabled, info.Threshold*100, info.SpeculativeHours, info.BudgetHours)
+	if !info.HoldUntil.IsZero() {
+		fmt.Printf("server kept running until %s\n", info.HoldUntil.Local().Format("Mon 15:04"))
+	}
+	if !info.NextSlot.IsZero() {
+		fmt.Printf("next likely hour: %s\n", info.NextSlot.Local().Format("Mon 15:04"))
+	}
+
+	// join probability (%) of each hour, "*" marks the hours over the threshold
+	fmt.Printf("\njoin probability learned since %s\n    ", info.Model.LearnedSince.Local().Format("2006-01-02"))
+	for h := 0; h < 24; h++ {
+		fmt.Printf("%4d", h)
+	}
+	fmt.Println()
+	for _, weekday := range []time.Weekday{1, 2, 3, 4, 5, 6, 0} {
+		fmt.Printf("%-4s", scheduleDays[weekday])
+		for h := 0; h < 24; h++ {
+			cell := "   ."
+			if info.Model.Samples[weekday][h] >= prewarmMinSamples {
+				probability := info.Model.Probability[weekday][h]
+				mark := " "
+				if probability >= info.Threshold {
+					mark = "*"
+				}
+				cell = fmt.Sprintf("%3.0f%s", probability*100, mark)
+			}
+			fmt.Print(cell)
+		}
+		fmt.Println()
+	}
+
+	if len(info.Decisions) > 0 {
+		fmt.Println("\ndecisions:")
+	}
+	for _, decision := range info.Decisions {
+		line := fmt.Sprintf("  %s  %s (%.0f%%)  %s", decision.Time.Local().Format("2006-01-02 15:04"), decision.Slot.Local().Format("Mon 15:04"), decision.Probability*100, decision.Action)
+		if decision.Reason != "" {
+			line += ": " + decision.Reason
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// errIncompletePacket is returned when the data does not contain a whole packet yet
+var errIncompletePacket = errors.New("incomplete packet")
+
+// errMalformedPacket is returned when the data can't be a valid minecraft packet
+var errMalformedPacket = errors.New("malformed packet")
+
+// handshake contains the fields of the first packet sent by a client
+type handshake struct {
+	ProtocolVersion int
+	ServerAddress   string
+	ServerPort      int
+	// 1 = status request, 2 = login (join)
+	NextState int
+}
+
+// readVarInt reads a minecraft VarInt from data and returns the value and the number of bytes used
+func readVarInt(data []byte) (int, int, error) {
+	var value uint32
+	for i := 0; i < 5; i++ {
+		if i >= len(data) {
+			return 0, 0, errIncompletePacket
+		}
+		value |= uint32(data[i]&0x7F) << (7 * i)
+		if data[i]&0x80 == 0 {
+			return int(int32(value)), i + 1, nil
+		}
+	}
+	return 0, 0, errMalformedPacket
+}
+
+// readString reads a minecraft string (VarInt length + utf8 bytes) from data and returns it and the number of bytes used
+func readString(data []byte) (string, int, error) {
+	length, n, err := readVarInt(data)
+	if err != nil {
+		return "", 0, err
+	}
+	if length < 0 || length > 32767*4 {
+		return "", 0, errMalformedPacket
+	}
+	if n+length > len(data) {
+		return "", 0, errIncompletePacket
+	}
+	return string(data[n : n+length]), n + length, nil
+}
+
+// readPacket splits the first packet from data and returns the packet id, the payload and the number of bytes used
+func readPacket(data []byte) (int, []byte, int, error) {
+	length, n, err := readVarInt(data)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if length <= 0 || length > 2097151 {
+		return 0, nil, 0, errMalformedPacket
+	}
+	if n+length > len(data) {
+		return 0, nil, 0, errIncompletePacket
+	}
+	packet := data[n : n+length]
+	id, idLen, err := readVarInt(packet)
+	if err != nil {
+		return 0, nil, 0, errMalformedPacket
+	}
+	return id, packet[idLen:], n + length, nil
+}
+
+// parseHandshake parses the handshake packet at the beginning of data and returns it and the number of bytes used
+func parseHandshake(data []byte) (handshake, int, error) {
+	var hs handshake
+
+	id, payload, total, err := readPacket(data)
+	if err != nil {
+		return hs, 0, err
+	}
+	if id != 0 {
+		return hs, 0, errMalformedPacket
+	}
+
+	protocolVersion, n, err := readVarInt(payload)
+	if err != nil {
+		return hs, 0, errMalformedPacket
+	}
+	payload = payload[n:]
+	serverAddress, n, err := readString(payload)
+	if err != nil || len(payload) < n+2 {
+		return hs, 0, errMalformedPacket
+	}
+	payload = payload[n:]
+	serverPort := int(payload[0])<<8 | int(payload[1])
+	nextState, _, err := readVarInt(payload[2:])
+	if err != nil {
+		return hs, 0, errMalformedPacket
+	}
+
+	hs = handshake{ProtocolVersion: protocolVersion, ServerAddress: serverAddress, ServerPort: serverPort, NextState: nextState}
+	return hs, total, nil
+}
+
+// parseLoginStart parses the login start packet at the beginning of data and returns the player name
+func parseLoginStart(data []byte) (string, error) {
+	id, payload, _, err := readPacket(data)
+	if err != nil {
+		return "", err
+	}
+	if id != 0 {
+		return "", errMalformedPacket
+	}
+	playerName, _, err := readString(payload)
+	if err != nil || len(playerName) == 0 || len(playerName) > 16 {
+		return "", errMalformedPacket
+	}
+	return playerName, nil
+}
+
+// readClientIntention reads the first packets sent by a client and returns all the data read,
+// the handshake next state (1 = status, 2 = login) and the player name (only for login).
+// the data read must be forwarded to the server as it is.
+func readClientIntention(clientSocket net.Conn) ([]byte, int, string, error) {
+	clientSocket.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer clientSocket.SetReadDeadline(time.Time{})
+
+	var data []byte
+	buffer := make([]byte, 1024)
+
+	for len(data) < 4096 {
+		dataLen, err := clientSocket.Read(buffer)
+		if err != nil {
+			return data, 0, "", err
+		}
+		data = append(data, buffer[:dataLen]...)
+
+		// legacy server list ping (clients older than 1.7)
+		if data[0] == 0xFE {
+			return data, 1, "", nil
+		}
+
+		hs, n, err := parseHandshake(data)
+		if err == errIncompletePacket {
+			continue
+		} else if err != nil {
+			return data, 0, "", err
+		}
+
+		if hs.NextState != 2 {
+			return data, hs.NextState, "", nil
+		}
+
+		playerName, err := parseLoginStart(data[n:])
+		if err == errIncompletePacket {
+			continue
+		} else if err != nil {
+			return data, hs.NextState, "", err
+		}
+		return data, hs.NextState, playerName, nil
+	}
+
+	return data, 0, "", errMalformedPacket
+}
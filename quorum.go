@@ -0,0 +1,73 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quorumVote is a player that tried to wake the server while the quorum was not reached
+type quorumVote struct {
+	voter string // player name (lowercase) or ip, see Quorum.DistinctBy
+	time  time.Time
+}
+
+// to keep track of the players waiting for the quorum
+var quorumVotes []quorumVote
+var quorumMutex = &sync.Mutex{}
+
+// isQuorumEnabled returns true if more than one player is needed to wake the server
+func isQuorumEnabled() bool {
+	return conf().Quorum.Players > 1
+}
+
+// castQuorumVote records that playerName tried to wake the server and returns the votes in the last
+// Quorum.Window seconds. if the quorum is reached the votes are cleared and reached is true
+func castQuorumVote(playerName, clientAddress string) (votes int, reached bool) {
+	voter := strings.ToLower(playerName)
+	if conf().Quorum.DistinctBy == "ip" {
+		voter = clientAddress
+	}
+
+	quorumMutex.Lock()
+	defer quorumMutex.Unlock()
+
+	pruneQuorumVotes()
+	// a player trying again only refreshes the vote
+	quorumVotes = slices.DeleteFunc(quorumVotes, func(vote quorumVote) bool { return vote.voter == voter })
+	quorumVotes = append(quorumVotes, quorumVote{voter: voter, time: time.Now()})
+
+	votes = len(quorumVotes)
+	if votes >= conf().Quorum.Players {
+		quorumVotes = nil
+		return votes, true
+	}
+	return votes, false
+}
+
+// pendingQuorumVotes returns how many players are waiting for the quorum
+func pendingQuorumVotes() int {
+	quorumMutex.Lock()
+	defer quorumMutex.Unlock()
+
+	pruneQuorumVotes()
+	return len(quorumVotes)
+}
+
+// pruneQuorumVotes removes the votes older than Quorum.Window seconds (quorumMutex must be locked)
+func pruneQuorumVotes() {
+	windowStart := time.Now().Add(-time.Duration(conf().Quorum.Window) * time.Second)
+	quorumVotes = slices.DeleteFunc(quorumVotes, func(vote quorumVote) bool { return vote.time.Before(windowStart) })
+}
+
+// isQuorumAdmin returns true if playerName can wake the server without waiting for the quorum
+func isQuorumAdmin(playerName string) bool {
+	return matchPlayerList(conf().Quorum.Admins, playerName, offlineUUID(playerName))
+}
+
+// quorumMessage replaces {votes} and {quorum} in message
+func quorumMessage(message string, votes int) string {
+	return strings.NewReplacer("{votes}", strconv.Itoa(votes), "{quorum}", strconv.Itoa(conf().Quorum.Players)).Replace(message)
+}
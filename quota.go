@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// playerWakes contains the recent wakes of a player (stored in WakeQuota.File)
+type playerWakes struct {
+	Player string      `json:"player"`
+	Wakes  []time.Time `json:"wakes"`
+}
+
+// wakeQuotaEntry is the quota state of a player shown by the "quota" command
+type wakeQuotaEntry struct {
+	Player     string    `json:"player"`
+	WakesToday int       `json:"wakesToday"`
+	LastWake   time.Time `json:"lastWake"`
+	// when the player can wake the server again (zero: now)
+	NextWake time.Time `json:"nextWake,omitzero"`
+}
+
+// to keep track of the wakes of each player (lowercase name -> wakes)
+var wakeQuotas = map[string]*playerWakes{}
+var wakeQuotaMutex = &sync.Mutex{}
+
+// loadWakeQuotas reads the wakes stored by the previous msh run
+func loadWakeQuotas() {
+	data, err := os.ReadFile(conf().WakeQuota.File)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		logMsh.Warn("loadWakeQuotas: error while reading the wake quotas", "error", err)
+		return
+	}
+
+	var list []playerWakes
+	if err := json.Unmarshal(data, &list); err != nil {
+		logMsh.Warn("loadWakeQuotas: invalid wake quotas file", "path", conf().WakeQuota.File, "error", err)
+		return
+	}
+
+	wakeQuotaMutex.Lock()
+	defer wakeQuotaMutex.Unlock()
+	for _, entry := range list {
+		wakeQuotas[strings.ToLower(entry.Player)] = &entry
+	}
+}
+
+// saveWakeQuotas writes the wakes to WakeQuota.File (wakeQuotaMutex must be locked)
+func saveWakeQuotas() {
+	list := []playerWakes{}
+	for _, entry := range wakeQuotas {
+		list = append(list, *entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Player < list[j].Player })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return
+	}
+	// the file is replaced at once so that a crash doesn't leave it half written
+	path := conf().WakeQuota.File
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		logMsh.Error("saveWakeQuotas: error while writing the wake quotas", "error", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		logMsh.Error("saveWakeQuotas: error while writing the wake quotas", "error", err)
+	}
+}
+
+// wakeQuotaDay returns the start of the current day in Schedule.Timezone (the daily quota resets at midnight)
+func wakeQuotaDay(now time.Time) time.Time {
+	now = now.In(conf().scheduleLocation())
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+// quotaState returns the wakes of entry since the start of the day and when the player can wake the server again
+// (zero if the player can wake it now)
+func (entry *playerWakes) quotaState(now time.Time) (int, time.Time) {
+	c := conf()
+	day := wakeQuotaDay(now)
+
+	wakesToday := 0
+	var lastWake time.Time
+	for _, wake := range entry.Wakes {
+		if !wake.Before(day) {
+			wakesToday++
+		}
+		lastWake = maxTime(lastWake, wake)
+	}
+
+	var nextWake time.Time
+	if c.WakeQuota.MaxPerDay > 0 && wakesToday >= c.WakeQuota.MaxPerDay {
+		nextWake = day.AddDate(0, 0, 1)
+	}
+	if cooldownEnd := lastWake.Add(time.Duration(c.WakeQuota.Cooldown) * time.Second); c.WakeQuota.Cooldown > 0 && cooldownEnd.After(now) {
+		nextWake = maxTime(nextWake, cooldownEnd)
+	}
+	return wakesToday, nextWake
+}
+
+// checkWakeQuota returns an error (the reason) and the text for the player if playerName can't wake the server
+// because of WakeQuota.MaxPerDay or WakeQuota.Cooldown
+func checkWakeQuota(playerName string) (string, error) {
+	c := conf()
+	now := time.Now()
+
+	wakeQuotaMutex.Lock()
+	entry, ok := wakeQuotas[strings.ToLower(playerName)]
+	var wakesToday int
+	var nextWake time.Time
+	if ok {
+		wakesToday, nextWake = entry.quotaState(now)
+	}
+	wakeQuotaMutex.Unlock()
+
+	if nextWake.IsZero() {
+		return "", nil
+	}
+
+	reset := nextWake.In(c.scheduleLocation()).Format("Mon 15:04")
+	if c.WakeQuota.MaxPerDay > 0 && wakesToday >= c.WakeQuota.MaxPerDay {
+		message := strings.NewReplacer("{limit}", strconv.Itoa(c.WakeQuota.MaxPerDay), "{reset}", reset).Replace(c.Messages.WakeQuotaExceeded)
+		return message, errors.New("daily wake limit reached")
+	}
+	cooldown := shortDuration(time.Duration(c.WakeQuota.Cooldown) * time.Second)
+	message := strings.NewReplacer("{limit}", cooldown, "{reset}", reset).Replace(c.Messages.WakeCooldown)
+	return message, errors.New("wake cooldown")
+}
+
+// recordPlayerWake counts a wake of playerName in the quotas (the wakes not needed anymore are forgotten)
+func recordPlayerWake(playerName string) {
+	if conf().WakeQuota.MaxPerDay <= 0 && conf().WakeQuota.Cooldown <= 0 {
+		return
+	}
+	now := time.Now()
+
+	wakeQuotaMutex.Lock()
+	defer wakeQuotaMutex.Unlock()
+
+	entry, ok := wakeQuotas[strings.ToLower(playerName)]
+	if !ok {
+		entry = &playerWakes{Player: playerName}
+		wakeQuotas[strings.ToLower(playerName)] = entry
+	}
+	entry.Wakes = append(entry.Wakes, now)
+
+	// the wakes of the previous days are needed only for the cooldown
+	keepSince := minTime(wakeQuotaDay(now), now.Add(-time.Duration(conf().WakeQuota.Cooldown)*time.Second))
+	for key, entry := range wakeQuotas {
+		wakes := entry.Wakes[:0]
+		for _, wake := range entry.Wakes {
+			if !wake.Before(keepSince) {
+				wakes = append(wakes, wake)
+			}
+		}
+		entry.Wakes = wakes
+		if len(entry.Wakes) == 0 {
+			delete(wakeQuotas, key)
+		}
+	}
+	saveWakeQuotas()
+}
+
+// resetWakeQuota forgets the wakes of playerName ("*": of all the players)
+func resetWakeQuota(playerName string) error {
+	wakeQuotaMutex.Lock()
+	defer wakeQuotaMutex.Unlock()
+
+	if playerName == "*" {
+		wakeQuotas = map[string]*playerWakes{}
+	} else if _, ok := wakeQuotas[strings.ToLower(playerName)]; ok {
+		delete(wakeQuotas, strings.ToLower(playerName))
+	} else {
+		return fmt.Errorf("no wakes recorded for player %q", playerName)
+	}
+	saveWakeQuotas()
+	return nil
+}
+
+// listWakeQuotas returns the quota state of the players that woke the server recently
+func listWakeQuotas() []wakeQuotaEntry {
+	now := time.Now()
+
+	wakeQuotaMutex.Lock()
+	defer wakeQuotaMutex.Unlock()
+
+	list := []wakeQuotaEntry{}
+	for _, entry := range wakeQuotas {
+		wakesToday, nextWake := entry.quotaState(now)
+		quota := wakeQuotaEntry{Player: entry.Player, WakesToday: wakesToday, NextWake: nextWake}
+		for _, wake := range entry.Wakes {
+			quota.LastWake = maxTime(quota.LastWake, wake)
+		}
+		list = append(list, quota)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Player < list[j].Player })
+	return list
+}
+
+// shortDuration formats d without the zero units at the end (example: "1h", "10m", "1m30s")
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
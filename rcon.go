@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// rcon packet types sent to the server
+const (
+	rconTypeCommand = 2
+	rconTypeLogin   = 3
+)
+
+// rconClient is a connection to the minecraft server rcon port
+type rconClient struct {
+	conn      net.Conn
+	requestID int32
+}
+
+// the rcon connection is kept open between commands
+var rcon *rconClient
+var rconMutex = &sync.Mutex{}
+
+// sendRconCommand sends command using the rcon connection (opened if needed) and returns the server answer
+func sendRconCommand(address, password, command string) (string, error) {
+	rconMutex.Lock()
+	defer rconMutex.Unlock()
+
+	// the connection is opened again once if it was closed by the server (example: server restarted)
+	for attempt := 0; attempt < 2; attempt++ {
+		if rcon == nil {
+			client, err := dialRcon(address, password)
+			if err != nil {
+				return "", err
+			}
+			rcon = client
+		}
+
+		response, err := rcon.command(command)
+		if err == nil {
+			return response, nil
+		}
+		logProcess.Debug("sendRconCommand: rcon connection lost", "error", err)
+		rcon.conn.Close()
+		rcon = nil
+	}
+	return "", errors.New("rcon connection lost")
+}
+
+// dialRcon opens a rcon connection and logs in with password
+func dialRcon(address, password string) (*rconClient, error) {
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("rcon: %v", err)
+	}
+	client := &rconClient{conn: conn}
+
+	id, err := client.write(rconTypeLogin, password)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("rcon: %v", err)
+	}
+	responseID, _, err := client.read()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("rcon: %v", err)
+	}
+	// the server answers with request id -1 if the password is wrong
+	if responseID != id {
+		conn.Close()
+		return nil, errors.New("rcon: wrong password")
+	}
+	return client, nil
+}
+
+// command sends a command and returns the answer
+func (c *rconClient) command(command string) (string, error) {
+	id, err := c.write(rconTypeCommand, command)
+	if err != nil {
+		return "", err
+	}
+	responseID, body, err := c.read()
+	if err != nil {
+		return "", err
+	}
+	if responseID != id {
+		return "", fmt.Errorf("unexpected response id %d", responseID)
+	}
+	return body, nil
+}
+
+// write sends a rcon packet and returns its request id
+func (c *rconClient) write(packetType int32, body string) (int32, error) {
+	c.requestID++
+
+	// packet: [length int32][request id int32][type int32][body][0][0]
+	var packet bytes.Buffer
+	binary.Write(&packet, binary.LittleEndian, int32(4+4+len(body)+2))
+	binary.Write(&packet, binary.LittleEndian, c.requestID)
+	binary.Write(&packet, binary.LittleEndian, packetType)
+	packet.WriteString(body)
+	packet.Write([]byte{0, 0})
+
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.conn.Write(packet.Bytes())
+	return c.requestID, err
+}
+
+// read receives a rcon packet and returns its request id and body
+func (c *rconClient) read() (int32, string, error) {
+	c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	var length int32
+	if err := binary.Read(c.conn, binary.LittleEndian, &length); err != nil {
+		return 0, "", err
+	}
+	if length < 10 || length > 1<<20 {
+		return 0, "", fmt.Errorf("invalid packet length %d", length)
+	}
+
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(c.conn, packet); err != nil {
+		return 0, "", err
+	}
+	requestID := int32(binary.LittleEndian.Uint32(packet[0:4]))
+	body := string(bytes.TrimRight(packet[8:], "\x00"))
+	return requestID, body, nil
+}
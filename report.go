@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// reportPeriods contains the periods in which the report can be grouped and how many of them are shown
+var reportPeriods = map[string]int{"day": 30, "week": 12, "month": 12}
+
+// reportRow is the summary of a period (or of the whole report)
+type reportRow struct {
+	Start                 time.Time `json:"start"`
+	End                   time.Time `json:"end"`
+	RunningHours          float64   `json:"runningHours"`
+	HibernatingHours      float64   `json:"hibernatingHours"`
+	ColdStarts            int       `json:"coldStarts"`
+	Sessions              int       `json:"sessions"`
+	AverageSessionSeconds int64     `json:"averageSessionSeconds"`
+	EnergyUsedKWh         float64   `json:"energyUsedKWh"`
+	EnergySavedKWh        float64   `json:"energySavedKWh"`
+	sessionSeconds        int64
+}
+
+// energyReport is the uptime and energy savings report
+type energyReport struct {
+	Period string      `json:"period"`
+	Rows   []reportRow `json:"rows"`
+	Total  reportRow   `json:"total"`
+}
+
+// stateInterval is a period of time in which the server was in the same state
+type stateInterval struct {
+	state string
+	start time.Time
+	end   time.Time
+}
+
+// mshStoppedState is the state recorded for the time msh was not running (counted neither as running nor as hibernating)
+const mshStoppedState = "msh_stopped"
+
+// recordMshStart writes when the previous msh run ended and the state of the server when msh starts,
+// so that the time msh was not running is not counted as time spent in the last state recorded.
+// the history file is touched every minute while msh runs: its modification time is when the previous run ended
+func recordMshStart() {
+	if !conf().History.Enabled {
+		return
+	}
+
+	historyMutex.Lock()
+	if info, err := os.Stat(conf().History.File); err == nil {
+		writeHistoryRecord(historyRecord{Type: "state", State: mshStoppedState, Since: info.ModTime(), Until: info.ModTime(), Reason: "msh stopped"})
+	}
+	writeHistoryRecord(historyRecord{Type: "state", State: serverStatus, Since: time.Now(), Until: time.Now(), Reason: "msh started"})
+	historyMutex.Unlock()
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			now := time.Now()
+			if err := os.Chtimes(conf().History.File, now, now); err != nil && !os.IsNotExist(err) {
+				logMsh.Debug("recordMshStart: cannot touch the history file", "error", err)
+			}
+		}
+	}()
+}
+
+// buildEnergyReport groups the recorded state changes and sessions by period ("day", "week" or "month")
+func buildEnergyReport(period string) (energyReport, error) {
+	count, ok := reportPeriods[period]
+	if !ok {
+		return energyReport{}, fmt.Errorf("unknown report period %q (valid: day, week, month)", period)
+	}
+
+	// the periods start at midnight, on monday or on the first day of the month (in Schedule.Timezone, like the budgets)
+	now := time.Now().In(conf().scheduleLocation())
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var next func(t time.Time) time.Time
+	switch period {
+	case "day":
+		start = start.AddDate(0, 0, -(count - 1))
+		next = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	case "week":
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7-7*(count-1))
+		next = func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }
+	case "month":
+		start = time.Date(now.Year(), now.Month()-time.Month(count-1), 1, 0, 0, 0, 0, now.Location())
+		next = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	}
+
+	// the whole history is read: the state at the start of the report was recorded before it
+	records, err := readHistory(time.Time{})
+	if err != nil {
+		return energyReport{}, err
+	}
+
+	report := energyReport{Period: period, Total: reportRow{Start: start, End: now}}
+	for rowStart := start; rowStart.Before(now); rowStart = next(rowStart) {
+		rowEnd := next(rowStart)
+		if rowEnd.After(now) {
+			rowEnd = now
+		}
+		report.Rows = append(report.Rows, reportRow{Start: rowStart, End: rowEnd})
+	}
+
+	var rowIndex = func(t time.Time) int {
+		for i := range report.Rows {
+			if !t.Before(report.Rows[i].Start) && t.Before(report.Rows[i].End) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	for _, interval := range stateIntervals(records, now) {
+		if interval.state == mshStoppedState {
+			continue
+		}
+		for i := range report.Rows {
+			row := &report.Rows[i]
+			overlap := minTime(interval.end, row.End).Sub(maxTime(interval.start, row.Start)).Hours()
+			if overlap <= 0 {
+				continue
+			}
+			if interval.state == "offline" {
+				row.HibernatingHours += overlap
+			} else {
+				row.RunningHours += overlap
+			}
+		}
+	}
+
+	for _, record := range records {
+		switch {
+		case record.Type == "state" && record.State == "starting" && record.Reason != "adopted":
+			if i := rowIndex(record.Since); i >= 0 {
+				report.Rows[i].ColdStarts++
+			}
+		case record.Type == "session":
+			if i := rowIndex(record.Until); i >= 0 {
+				report.Rows[i].Sessions++
+				report.Rows[i].sessionSeconds += int64(record.Until.Sub(record.Since).Seconds())
+			}
+		}
+	}
+
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		row.finish()
+		report.Total.RunningHours += row.RunningHours
+		report.Total.HibernatingHours += row.HibernatingHours
+		report.Total.ColdStarts += row.ColdStarts
+		report.Total.Sessions += row.Sessions
+		report.Total.sessionSeconds += row.sessionSeconds
+	}
+	report.Total.finish()
+
+	return report, nil
+}
+
+// finish computes the average session length and the energy of the row.
+// while hibernating the host uses Energy.IdleWatts instead of Energy.RunningWatts: the difference is saved
+func (row *reportRow) finish() {
+	if row.Sessions > 0 {
+		row.AverageSessionSeconds = row.sessionSeconds / int64(row.Sessions)
+	}
+	runningWatts, idleWatts := conf().Energy.RunningWatts, conf().Energy.IdleWatts
+	row.EnergyUsedKWh = (row.RunningHours*runningWatts + row.HibernatingHours*idleWatts) / 1000
+	row.EnergySavedKWh = row.HibernatingHours * (runningWatts - idleWatts) / 1000
+}
+
+// stateIntervals converts the recorded state changes in intervals (the last one ends now)
+func stateIntervals(records []historyRecord, now time.Time) []stateInterval {
+	var changes []historyRecord
+	for _, record := range records {
+		if record.Type == "state" {
+			changes = append(changes, record)
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Since.Before(changes[j].Since) })
+
+	var intervals []stateInterval
+	for i, change := range changes {
+		end := now
+		if i+1 < len(changes) {
+			end = changes[i+1].Since
+		}
+		intervals = append(intervals, stateInterval{state: change.State, start: change.Since, end: end})
+	}
+	return intervals
+}
+
+// printEnergyReport prints the report received from the running msh as a table
+func printEnergyReport(data interface{}) {
+	var report energyReport
+	encoded, _ := json.Marshal(data)
+	if err := json.Unmarshal(encoded, &report); err != nil {
+		fmt.Println(string(encoded))
+		return
+	}
+
+	var printRow = func(name string, row reportRow) {
+		fmt.Printf("%-10s %8.1fh %11.1fh %12d %9d %12s %9.2f %10.2f\n", name, row.RunningHours, row.HibernatingHours, row.ColdStarts, row.Sessions,
+			(time.Duration(row.AverageSessionSeconds) * time.Second).String(), row.EnergyUsedKWh, row.EnergySavedKWh)
+	}
+
+	layout := map[string]string{"day": "2006-01-02", "week": "2006-01-02", "month": "2006-01"}[report.Period]
+	fmt.Printf("%-10s %9s %12s %12s %9s %12s %9s %10s\n", report.Period, "running", "hibernating", "cold starts", "sessions", "avg session", "kWh used", "kWh saved")
+	for _, row := range report.Rows {
+		printRow(row.Start.Format(layout), row)
+	}
+	printRow("total", report.Total)
+}
+
+// minTime returns the earliest of a and b
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// maxTime returns the latest of a and b
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scheduleModes contains the modes of the schedule windows:
+// "always_on" (the server is started and never stopped when empty), "wakeable" (the server is started by the
+// players joining, the default outside the windows), "closed" (the server is stopped and can't be woken)
+var scheduleModes = []string{"always_on", "wakeable", "closed"}
+
+// scheduleDays contains the names of the days used by the schedule windows (index: time.Weekday)
+var scheduleDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// scheduleWindow is a time window of the schedule
+type scheduleWindow struct {
+	// one of scheduleModes
+	Mode string
+	// days in which the window starts: "mon", "tue", ... or ranges like "mon-fri" (empty: every day)
+	Days []string
+	// "HH:MM" in Schedule.Timezone. if Until is not after From the window ends the next day
+	From  string
+	Until string
+}
+
+// to keep track of the schedule mode applied by watchSchedule()
+var currentScheduleMode = "wakeable"
+var scheduleMutex = &sync.Mutex{}
+
+// timezones already loaded (name -> location)
+var scheduleLocations = map[string]*time.Location{}
+
+// scheduleLocation returns the timezone of the schedule windows
+func (c *configuration) scheduleLocation() *time.Location {
+	if c.Schedule.Timezone == "" {
+		return time.Local
+	}
+
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	location, ok := scheduleLocations[c.Schedule.Timezone]
+	if !ok {
+		var err error
+		location, err = time.LoadLocation(c.Schedule.Timezone)
+		if err != nil {
+			// the timezone was already checked by validate()
+			return time.Local
+		}
+		scheduleLocations[c.Schedule.Timezone] = location
+	}
+	return location
+}
+
+// scheduleMode returns the mode of the schedule at t (the first window that contains t wins)
+func scheduleMode(t time.Time) string {
+	c := conf()
+	t = t.In(c.scheduleLocation())
+	for _, window := range c.Schedule.Windows {
+		if window.contains(t) {
+			return window.Mode
+		}
+	}
+	return "wakeable"
+}
+
+// nextScheduleOpening returns the first time after t in which the schedule is not "closed"
+// (zero if the schedule is closed for the next 8 days)
+func nextScheduleOpening(t time.Time) time.Time {
+	for next := t.Truncate(time.Minute); next.Before(t.Add(8 * 24 * time.Hour)); next = next.Add(time.Minute) {
+		if next.After(t) && scheduleMode(next) != "closed" {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// contains returns true if t is inside the window (t must be in the schedule timezone)
+func (window scheduleWindow) contains(t time.Time) bool {
+	from, _ := parseClock(window.From)
+	until, _ := parseClock(window.Until)
+	if until <= from {
+		until += 24 * 60
+	}
+	// the window can start the day before t
+	for _, dayOffset := range []int{0, -1} {
+		start := time.Date(t.Year(), t.Month(), t.Day()+dayOffset, 0, from, 0, 0, t.Location())
+		end := time.Date(t.Year(), t.Month(), t.Day()+dayOffset, 0, until, 0, 0, t.Location())
+		if window.startsOn(start.Weekday()) && !t.Before(start) && t.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// startsOn returns true if the window starts on weekday
+func (window scheduleWindow) startsOn(weekday time.Weekday) bool {
+	if len(window.Days) == 0 {
+		return true
+	}
+	for _, days := range window.Days {
+		first, last, _ := parseScheduleDays(days)
+		// ranges can wrap around the end of the week (example: "fri-mon")
+		if (first <= last && weekday >= first && weekday <= last) || (first > last && (weekday >= first || weekday <= last)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScheduleDays parses a day ("mon") or a range of days ("mon-fri")
+func parseScheduleDays(days string) (time.Weekday, time.Weekday, error) {
+	var parseDay = func(day string) (time.Weekday, error) {
+		for i, name := range scheduleDays {
+			if strings.EqualFold(day, name) {
+				return time.Weekday(i), nil
+			}
+		}
+		return 0, fmt.Errorf("invalid day %q (valid: %s)", day, strings.Join(scheduleDays, ", "))
+	}
+
+	firstName, lastName, isRange := strings.Cut(days, "-")
+	first, err := parseDay(firstName)
+	if err != nil || !isRange {
+		return first, first, err
+	}
+	last, err := parseDay(lastName)
+	return first, last, err
+}
+
+// parseClock parses "HH:MM" and returns the minutes since midnight ("24:00" is accepted as end of the day)
+func parseClock(clock string) (int, error) {
+	hours, minutes, ok := strings.Cut(clock, ":")
+	h, errH := strconv.Atoi(hours)
+	m, errM := strconv.Atoi(minutes)
+	if !ok || errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q (format: HH:MM)", clock)
+	}
+	return h*60 + m, nil
+}
+
+// watchSchedule applies the schedule every 30 seconds
+func watchSchedule() {
+	applySchedule()
+	for range time.Tick(30 * time.Second) {
+		applySchedule()
+	}
+}
+
+// applySchedule starts the server in the "always_on" windows and stops it in the "closed" windows
+// (nothing is done during maintenance)
+func applySchedule() {
+	mode := scheduleMode(time.Now())
+
+	scheduleMutex.Lock()
+	previousMode := currentScheduleMode
+	currentScheduleMode = mode
+	scheduleMutex.Unlock()
+
+	if mode != previousMode {
+		logMsh.Info("schedule mode changed", "mode", mode, "previous_mode", previousMode, "state", serverStatus)
+	}
+	if isMaintenance() {
+		return
+	}
+
+	switch mode {
+	case "always_on":
+		if serverStatus == "offline" && budgetExhaustedUntil(time.Now()).IsZero() {
+			if err := startMinecraftServer(event{Reason: "schedule"}); err != nil {
+				logMsh.Warn("applySchedule: error while starting the minecraft server", "error", err)
+			}
+		}
+
+	case "closed":
+		// the players online are warned and the server is stopped at the end of the countdown
+		if serverStatus != "offline" && !countdownRunning.Load() {
+			go stopWithCountdown(time.Now().Add(countdownDuration()), "schedule", false, func() bool {
+				return scheduleMode(time.Now()) == "closed" && !isMaintenance()
+			})
+		}
+
+	case "wakeable":
+		// the server was kept running by the "always_on" window: stop it if it's empty
+		if previousMode == "always_on" && serverStatus == "online" {
+			mutex.Lock()
+			stopInstances++
+			mutex.Unlock()
+			time.AfterFunc(time.Duration(conf().Basic.TimeBeforeStoppingEmptyServer)*time.Second, func() { stopEmptyMinecraftServer(false) })
+		}
+	}
+}
+
+// scheduleClosedMessage returns the text shown to the players that try to join while the schedule is closed
+func scheduleClosedMessage() string {
+	opening := "later"
+	if next := nextScheduleOpening(time.Now()); !next.IsZero() {
+		opening = next.In(conf().scheduleLocation()).Format("Mon 15:04")
+	}
+	return strings.ReplaceAll(conf().Messages.ScheduleClosed, "{opening}", opening)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// session contains the info about a client connection proxied to the minecraft server
+type session struct {
+	ID            int64     `json:"id"`
+	Kind          string    `json:"kind"` // "join" or "status"
+	PlayerName    string    `json:"player,omitempty"`
+	ClientAddress string    `json:"clientAddress"`
+	Since         time.Time `json:"since"`
+	Until         time.Time `json:"until,omitempty"`
+	BytesToServer int64     `json:"bytesToServer"`
+	BytesToClient int64     `json:"bytesToClient"`
+	// why the connection was closed (set by the first direction that stops)
+	DisconnectReason string `json:"disconnectReason,omitempty"`
+	// last time the client sent more than Afk.BytesPerMinute in a minute and whether the player is afk (join only)
+	LastActive time.Time `json:"lastActive"`
+	AFK        bool      `json:"afk"`
+	// client to server traffic of the current minute (unix nanoseconds of its start and bytes, accessed with atomic)
+	activityStart int64
+	activityBytes int64
+	// unix nanoseconds of LastActive (accessed with atomic)
+	lastActive int64
+}
+
+// to keep track of the open sessions
+var sessions = map[int64]*session{}
+var sessionsMutex = &sync.Mutex{}
+
+// id assigned to the last session opened
+var lastSessionID int64
+
+// to keep track of the last closed sessions
+var recentSessions []session
+
+const recentSessionsLength = 50
+
+// openSession registers a new session and returns it
+func openSession(kind, playerName, clientAddress string) *session {
+	s := &session{
+		ID:            atomic.AddInt64(&lastSessionID, 1),
+		Kind:          kind,
+		PlayerName:    playerName,
+		ClientAddress: clientAddress,
+		Since:         time.Now(),
+	}
+	s.lastActive = s.Since.UnixNano()
+
+	sessionsMutex.Lock()
+	sessions[s.ID] = s
+	sessionsMutex.Unlock()
+
+	return s
+}
+
+// close removes the session from the open sessions and adds it to the recent sessions
+func (s *session) close() {
+	sessionsMutex.Lock()
+	closed := s.snapshot()
+	closed.Until = time.Now()
+	delete(sessions, s.ID)
+	recentSessions = append(recentSessions, closed)
+	if len(recentSessions) > recentSessionsLength {
+		recentSessions = recentSessions[len(recentSessions)-recentSessionsLength:]
+	}
+	sessionsMutex.Unlock()
+
+	if closed.Kind == "join" {
+		recordSessionHistory(closed)
+	}
+}
+
+// setDisconnectReason records why the session was closed (only the first reason is kept)
+func (s *session) setDisconnectReason(reason string) {
+	sessionsMutex.Lock()
+	if s.DisconnectReason == "" {
+		s.DisconnectReason = reason
+	}
+	sessionsMutex.Unlock()
+}
+
+// addBytes adds dataLen to the bytes count of the session in the specified direction
+func (s *session) addBytes(dataLen int, isServerToClient bool) {
+	recordProxiedBytes(dataLen, isServerToClient)
+	if isServerToClient {
+		atomic.AddInt64(&s.BytesToClient, int64(dataLen))
+	} else {
+		atomic.AddInt64(&s.BytesToServer, int64(dataLen))
+		s.addActivity(dataLen)
+	}
+}
+
+// addActivity counts the client to server traffic of the current minute: the player is active when it
+// exceeds Afk.BytesPerMinute (an afk client only sends keep-alive and small movement packets)
+func (s *session) addActivity(dataLen int) {
+	now := time.Now().UnixNano()
+	if now-atomic.LoadInt64(&s.activityStart) >= int64(time.Minute) {
+		atomic.StoreInt64(&s.activityStart, now)
+		atomic.StoreInt64(&s.activityBytes, 0)
+	}
+	if atomic.AddInt64(&s.activityBytes, int64(dataLen)) >= int64(conf().Afk.BytesPerMinute) {
+		atomic.StoreInt64(&s.lastActive, now)
+	}
+}
+
+// snapshot returns a copy of the session that can be read safely (sessionsMutex must be locked).
+// the fields changed by the forwarding goroutines are read with atomic, the others are copied
+func (s *session) snapshot() session {
+	lastActive := time.Unix(0, atomic.LoadInt64(&s.lastActive))
+	return session{
+		ID:               s.ID,
+		Kind:             s.Kind,
+		PlayerName:       s.PlayerName,
+		ClientAddress:    s.ClientAddress,
+		Since:            s.Since,
+		Until:            s.Until,
+		BytesToServer:    atomic.LoadInt64(&s.BytesToServer),
+		BytesToClient:    atomic.LoadInt64(&s.BytesToClient),
+		DisconnectReason: s.DisconnectReason,
+		LastActive:       lastActive,
+		AFK:              s.Kind == "join" && conf().Afk.Minutes > 0 && time.Since(lastActive) >= time.Duration(conf().Afk.Minutes)*time.Minute,
+	}
+}
+
+// listSessions returns a copy of the open sessions ordered by id
+func listSessions() []session {
+	sessionsMutex.Lock()
+	list := make([]session, 0, len(sessions))
+	for _, s := range sessions {
+		list = append(list, s.snapshot())
+	}
+	sessionsMutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// listRecentSessions returns a copy of the last closed sessions (most recent first)
+func listRecentSessions() []session {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	list := make([]session, len(recentSessions))
+	for i, s := range recentSessions {
+		list[len(recentSessions)-1-i] = s
+	}
+	return list
+}
+
+// onlinePlayerNames returns the names of the players connected to the server
+func onlinePlayerNames() []string {
+	names := []string{}
+	for _, s := range listSessions() {
+		if s.Kind == "join" {
+			names = append(names, s.PlayerName)
+		}
+	}
+	return names
+}
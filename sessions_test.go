@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSessionAFK(t *testing.T) {
+	useTestConfiguration(t, func(c *configuration) {
+		c.Afk.Minutes = 5
+		c.Afk.BytesPerMinute = 1000
+	})
+
+	s := openSession("join", "Steve", "127.0.0.1")
+	defer s.close()
+
+	// a player is active when it joins
+	if snapshot := s.snapshot(); snapshot.AFK {
+		t.Error("player afk right after joining")
+	}
+
+	atomic.StoreInt64(&s.lastActive, time.Now().Add(-6*time.Minute).UnixNano())
+	if !s.snapshot().AFK {
+		t.Error("player not afk after Afk.Minutes without traffic")
+	}
+
+	// keep-alive traffic doesn't make the player active
+	s.addBytes(400, false)
+	s.addBytes(400, false)
+	s.addBytes(5000, true)
+	if !s.snapshot().AFK {
+		t.Error("player active with less than Afk.BytesPerMinute sent")
+	}
+	s.addBytes(400, false)
+	if snapshot := s.snapshot(); snapshot.AFK || time.Since(snapshot.LastActive) > time.Second {
+		t.Errorf("player afk after sending Afk.BytesPerMinute (last active %v)", snapshot.LastActive)
+	}
+
+	// status connections are never afk
+	status := openSession("status", "", "127.0.0.1")
+	defer status.close()
+	atomic.StoreInt64(&status.lastActive, time.Now().Add(-time.Hour).UnixNano())
+	if status.snapshot().AFK {
+		t.Error("status session afk")
+	}
+}
+
+// TestSessionConcurrentAccess reads the sessions while the forwarding goroutines count the bytes (run with -race)
+func TestSessionConcurrentAccess(t *testing.T) {
+	useTestConfiguration(t, func(c *configuration) { c.Afk.Minutes = 1 })
+
+	s := openSession("join", "Alex", "127.0.0.1")
+
+	var wg sync.WaitGroup
+	for _, isServerToClient := range []bool{false, true} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				s.addBytes(100, isServerToClient)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			listSessions()
+			afkPlayerNames()
+		}
+	}()
+	wg.Wait()
+	s.close()
+
+	closed := listRecentSessions()[0]
+	if closed.ID != s.ID || closed.BytesToServer != 100000 || closed.BytesToClient != 100000 {
+		t.Errorf("closed session = %+v, want 100000 bytes in each direction", closed)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// validPlayerName matches the names that a minecraft client can use
+var validPlayerName = regexp.MustCompile(`^[A-Za-z0-9_]{1,16}$`)
+
+// serverPlayerEntry is an entry of whitelist.json, ops.json and banned-players.json
+type serverPlayerEntry struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+	// banned-players.json only: "forever" or the time the ban expires
+	Expires string `json:"expires"`
+}
+
+// checkWakeAllowed returns nil if playerName can wake the server, otherwise an error with the reason.
+// the players in Wake.Denylist (and in banned-players.json) are always denied, then if an allow source is configured
+// (Wake.Allowlist, whitelist.json, ops.json) the player must be in at least one of them
+func checkWakeAllowed(playerName string) error {
+	c := conf()
+
+	if !validPlayerName.MatchString(playerName) {
+		return fmt.Errorf("invalid player name")
+	}
+	uuid := offlineUUID(playerName)
+
+	if matchPlayerList(c.Wake.Denylist, playerName, uuid) {
+		return fmt.Errorf("in denylist")
+	}
+	if c.Wake.UseBannedPlayers {
+		for _, entry := range readServerPlayerList("banned-players.json") {
+			if entry.matches(playerName, uuid) && !entry.banExpired() {
+				return fmt.Errorf("banned")
+			}
+		}
+	}
+
+	if len(c.Wake.Allowlist) == 0 && !c.Wake.UseWhitelist && !c.Wake.UseOps {
+		return nil
+	}
+	if matchPlayerList(c.Wake.Allowlist, playerName, uuid) {
+		return nil
+	}
+	if c.Wake.UseOps && isServerOp(playerName) {
+		return nil
+	}
+	if c.Wake.UseWhitelist {
+		for _, entry := range readServerPlayerList("whitelist.json") {
+			if entry.matches(playerName, uuid) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("not in allowlist")
+}
+
+// isServerOp returns true if playerName is listed in ops.json
+func isServerOp(playerName string) bool {
+	uuid := offlineUUID(playerName)
+	for _, entry := range readServerPlayerList("ops.json") {
+		if entry.matches(playerName, uuid) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPlayerList returns true if list contains the player name (case insensitive) or its uuid
+func matchPlayerList(list []string, playerName, uuid string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, playerName) || normalizeUUID(item) == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// readServerPlayerList reads one of the player lists of the minecraft server ({McPath}fileName).
+// a missing or invalid file is an empty list
+func readServerPlayerList(fileName string) []serverPlayerEntry {
+	data, err := os.ReadFile(conf().Basic.McPath + fileName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logMsh.Warn("readServerPlayerList: error while reading player list", "file", fileName, "error", err)
+		}
+		return nil
+	}
+	var entries []serverPlayerEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		logMsh.Warn("readServerPlayerList: invalid player list", "file", fileName, "error", err)
+		return nil
+	}
+	return entries
+}
+
+// matches returns true if the entry refers to the player (by name or by offline mode uuid)
+func (entry serverPlayerEntry) matches(playerName, uuid string) bool {
+	return strings.EqualFold(entry.Name, playerName) || normalizeUUID(entry.UUID) == uuid
+}
+
+// banExpired returns true if the ban has an expiration time that is already passed
+func (entry serverPlayerEntry) banExpired() bool {
+	if entry.Expires == "" || entry.Expires == "forever" {
+		return false
+	}
+	expires, err := time.Parse("2006-01-02 15:04:05 -0700", entry.Expires)
+	return err == nil && expires.Before(time.Now())
+}
+
+// offlineUUID returns the uuid used by servers in offline mode for playerName
+// (version 3 uuid of "OfflinePlayer:<name>", without dashes)
+func offlineUUID(playerName string) string {
+	sum := md5.Sum([]byte("OfflinePlayer:" + playerName))
+	sum[6] = sum[6]&0x0f | 0x30
+	sum[8] = sum[8]&0x3f | 0x80
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeUUID removes the dashes from uuid and converts it to lowercase
+func normalizeUUID(uuid string) string {
+	return strings.ToLower(strings.ReplaceAll(uuid, "-", ""))
+}